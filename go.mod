@@ -10,11 +10,11 @@ require (
 	github.com/omakoto/go-common v0.0.0-20230902054104-3c406b670d93
 	github.com/pborman/getopt/v2 v2.1.0
 	github.com/stretchr/testify v1.8.4
+	golang.org/x/sys v0.11.0
 	golang.org/x/term v0.11.0
 )
 
 require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.11.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -52,13 +52,16 @@ func parseArgs(h *highlighter.Highlighter, args []string, takeInput bool, argume
 		pattern, colors := nextPatternAndColors()
 
 		if peek(0) != argumentSeparator {
-			h.AddSimpleRule(pattern, colors)
+			err = h.AddSimpleRule(pattern, colors)
 		} else {
 			pos++
 			pattern2, colors2 := nextPatternAndColors()
-			h.AddSimpleRangeRules(pattern, colors, pattern2, colors2)
+			err = h.AddSimpleRangeRules(pattern, colors, pattern2, colors2)
 			h.SetDefaultHide(true) // Range patterns imply -n.
 		}
+		if err != nil {
+			return
+		}
 	}
 	return
 }
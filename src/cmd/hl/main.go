@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"runtime/pprof"
+	"time"
 )
 
 const (
@@ -20,12 +21,16 @@ const (
 )
 
 var (
-	ruleFile = getopt.StringLong("rule", 'r', "", "Specify TOML rule file.")
+	ruleFile   = getopt.StringLong("rule", 'r', "", "Specify TOML rule file.")
+	theme      = getopt.StringLong("theme", 0, "", "Specify a theme file, or a theme name in ~/.config/hl/themes/, defining color aliases.")
+	background = getopt.StringLong("background", 0, "auto", "Specify the terminal background, used to choose color variants: auto, dark or light.")
 
 	after             = getopt.IntLong("after", 'A', 0, "Specify number of 'after' context lines.")
 	before            = getopt.IntLong("before", 'B', 0, "Specify number of 'before' context lines.")
 	context           = getopt.IntLong("context", 'C', 0, "Specify number of context lines.")
 	ignoreCase        = getopt.BoolLong("ignore-case", 'i', "Perform case insensitive match.")
+	fixedStrings      = getopt.BoolLong("fixed-strings", 'F', "Treat all patterns as fixed strings, like the {=} prefix.")
+	wordRegexp        = getopt.BoolLong("word-regexp", 0, "Only match whole words, like the {w} prefix.")
 	defaultHide       = getopt.BoolLong("hide", 'n', "Hide all lines by default.")
 	noSkipMarker      = getopt.BoolLong("no-skip-marker", 'S', "Suppress skip markers.")
 	execute           = getopt.BoolLong("command", 'c', "Execute command and process its output. Use ',' (or -s) to separate from filter specs.")
@@ -34,14 +39,41 @@ var (
 	cpuprofile        = getopt.StringLong("cpuprofile", 'P', "", "Write cpu profile to file.")
 	help              = getopt.BoolLong("help", 'h', "Show this help.")
 	noTtyWarning      = getopt.BoolLong("no-tty-warning", 'q', "Don't show warning even when stdin is tty.")
-	autoColor         = getopt.BoolLong("auto-color", 'a', "Disable coloring if stdout is not a terminal.")
+	autoColor         = getopt.BoolLong("auto-color", 'a', "Disable coloring if stdout is not a terminal. Same as --color=auto.")
+	colorMode         = getopt.StringLong("color", 0, "", "When to use colors: always, never or auto. (default: always, unless NO_COLOR is set)")
+	colorDepth        = getopt.StringLong("color-depth", 0, "auto", "Specify the number of colors: 8, 256 or truecolor.")
+	engine            = getopt.StringLong("engine", 0, "auto", "Specify the regex engine: auto, pcre or go. auto uses Go's engine for patterns that don't need PCRE.")
+	noPcre            = getopt.BoolLong("no-pcre", 'N', "Disable PCRE and use Go's regexp engine instead. Same as --engine=go.")
 	readFiles         = getopt.BoolLong("files", 'f', "Read from files instead of stdin. Use ',' (or -s) to separate from filter specs.")
 	argumentSeparator = getopt.StringLong("range-separator", 's', ArgumentSeparator, "Specify argument separator. (default="+ArgumentSeparator+")")
+	ansiInput         = getopt.BoolLong("ansi-input", 'R', "Match patterns against the input without escape sequences, and keep colors in the input.")
+	stripInputColors  = getopt.BoolLong("strip-input-colors", 0, "Match patterns against the input without escape sequences, and remove colors in the input.")
+	lineBuffered      = getopt.BoolLong("line-buffered", 0, "Flush the output after each line. (default: true if stdout or stdin is a terminal)")
+	disableTimedOut   = getopt.BoolLong("disable-timed-out-rules", 0, "Disable a rule for the rest of the input once its match times out. See --match-timeout.")
+	sanitize          = getopt.BoolLong("sanitize", 0, "Show control characters and escape sequences other than colors in the input as ^X. Use --sanitize=false to disable. (default: true if stdout is a terminal)")
+
+	minContrast = 0.0
+
+	maxStateDepth     = highlighter.DefaultMaxStateDepth
+	rangeTimeoutLines = 0
+
+	// out is the buffered stdout.
+	out *util.BufferedWriter
 )
 
+// outputFlushDelay is how long buffered output can be held while waiting for more input.
+const outputFlushDelay = 100 * time.Millisecond
+
+// defaultMatchTimeout is the default of --match-timeout.
+const defaultMatchTimeout = time.Second
+
 func init() {
 	getopt.FlagLong(&util.Debug, "debug", 'd', "Enable debug output.")
-	getopt.FlagLong(&matcher.NoPcre, "no-pcre", 'N', "Disable PCRE and use Go's regexp engine instead.")
+	matcher.MatchTimeout = defaultMatchTimeout
+	getopt.FlagLong(&matcher.MatchTimeout, "match-timeout", 0, "Give up a PCRE match after this long, e.g. for patterns with catastrophic backtracking. 0 disables the timeout.", "DURATION")
+	getopt.FlagLong(&maxStateDepth, "max-state-depth", 0, "Specify the maximum number of states saved by push_state. The oldest one is dropped when exceeded.", "N")
+	getopt.FlagLong(&rangeTimeoutLines, "range-timeout-lines", 0, "End a range N lines after its start if the end pattern doesn't appear. 0 disables the timeout.", "N")
+	getopt.FlagLong(&minContrast, "min-contrast", 0, "Adjust foreground colors to have at least this contrast ratio [1-21] against RGB backgrounds. (e.g. 4.5)", "RATIO")
 
 	getopt.SetUsage(usage)
 }
@@ -72,7 +104,7 @@ Basic usage:
     PATTERN [ COLOR-SPEC ] ',' PATTERN [ COLOR-SPEC ]
 
   COLOR-SPEC is:
-    '@' [ATTRS] [FG-COLOR] [/BG-COLOR] [ '@' [ATTRS] [LINE-FG-COLOR] [/LINE-BG-COLOR] ]
+    '@' [ATTRS] [FG-COLOR] [/BG-COLOR] [:UL-COLOR] [ '@' [ATTRS] [LINE-FG-COLOR] [/LINE-BG-COLOR] [:LINE-UL-COLOR] ]
 
   ATTRS is a set of:
     b: Bold / intense
@@ -85,6 +117,10 @@ Basic usage:
     black | red | green | yellow | blue | magenta | cyan | white
     [0-5][0-5][0-5]     (RGB: 216 colors)
     [0-9a-f]{6}         (RRGGBB: 24bit colors)
+    bright(black|red|...|white)  (16 ANSI colors)
+    c[0-255]            (xterm 256 color palette index)
+    gray[0-23]          (24 step grayscale ramp)
+    CSS/X11 color names (e.g. orange, navy, teal)
 
   Examples:
     # Highlight "ERROR" and "WARNING" in stdout/stdin with auto-selected colors:
@@ -96,6 +132,9 @@ Basic usage:
     # Highlight "ERROR" with both red foreground and yellow background:
       hl 'ERROR' @red/yellow
 
+    # Underline "TODO" in red without changing its foreground color:
+      hl 'TODO' @u:red
+
     # Highlight "ERROR" in bold red and color its entire line background dark red:
       hl 'ERROR' @bred@/200
 
@@ -126,6 +165,9 @@ Basic usage:
     # Run "make" and highlight its stdout (and stderr with -2):
       hl -c -2 make , 'error' @bred 'warning' @byellow
 
+    # Highlight output that is already colored, keeping its colors:
+      git log --color | hl -R 'Merge' @bred
+
 Options:
 `)
 	getopt.CommandLine.PrintOptions(os.Stderr)
@@ -146,6 +188,20 @@ func preprocessOptions() {
 		*before = *context
 	}
 
+	if minContrast != 0 && (minContrast < 1 || minContrast > 21) {
+		Fatalf("--min-contrast must be between 1 and 21.\n")
+	}
+	if maxStateDepth < 0 {
+		Fatalf("--max-state-depth must be 0 or greater.\n")
+	}
+	if rangeTimeoutLines < 0 {
+		Fatalf("--range-timeout-lines must be 0 or greater.\n")
+	}
+
+	if *ansiInput && *stripInputColors {
+		Fatalf("Cannot use -R and --strip-input-colors at the same time.\n")
+	}
+
 	if *execute && *readFiles {
 		Fatalf("Cannot use -c and -f at the same time.\n")
 	}
@@ -157,19 +213,68 @@ func main() {
 	preprocessOptions()
 
 	// Initialize highlighter.
-	var h *highlighter.Highlighter
-	if *autoColor && !isatty.IsTerminal(os.Stdout.Fd()) {
-		h = highlighter.NewHighlighterWithTerm(term.NewDumbTerm())
-	} else {
-		h = highlighter.NewHighlighter()
+	mode, err := term.ParseColorMode(*colorMode)
+	if err != nil {
+		Fatalf("%s", err)
+	}
+	if *autoColor && mode == term.ColorDefault {
+		mode = term.ColorAuto
+	}
+	depth, err := term.ParseColorDepth(*colorDepth)
+	if err != nil {
+		Fatalf("%s", err)
+	}
+	matcher.DefaultEngine, err = matcher.ParseEngine(*engine)
+	if err != nil {
+		Fatalf("%s", err)
 	}
+	if *noPcre {
+		matcher.DefaultEngine = matcher.EngineGo
+	}
+	h := highlighter.NewHighlighterWithTerm(term.NewTerm(mode, depth))
 	h.SetIgnoreCase(*ignoreCase)
+	h.SetFixedStrings(*fixedStrings)
+	h.SetWordRegexp(*wordRegexp)
+	h.SetDisableTimedOutRules(*disableTimedOut)
 	h.SetDefaultHide(*defaultHide)
 	h.SetDefaultBefore(*before)
 	h.SetDefaultAfter(*after)
 	h.SetNoSkipMarker(*noSkipMarker)
+	h.SetMinContrast(minContrast)
+	h.SetMaxStateDepth(maxStateDepth)
+	h.SetRangeTimeoutLines(rangeTimeoutLines)
+	if *ansiInput {
+		h.SetInputColors(highlighter.InputColorsKeep)
+	} else if *stripInputColors {
+		h.SetInputColors(highlighter.InputColorsStrip)
+	}
+	if !getopt.IsSet("sanitize") {
+		*sanitize = isatty.IsTerminal(os.Stdout.Fd())
+	}
+	h.SetSanitize(*sanitize)
+	bg, err := term.ParseBackground(*background)
+	if err != nil {
+		Fatalf("%s", err)
+	}
+	h.SetBackground(bg)
 	util.Dump("Highlighter (start): ", h)
 
+	// Load the theme first, so all the rules can use its color aliases.
+	if *theme != "" {
+		err := h.LoadTheme(*theme)
+		if err != nil {
+			Fatalf("Unable to read theme file: %s", err)
+		}
+	}
+
+	// Then the aliases in the rule file, so the inline rules can use them too.
+	if *ruleFile != "" {
+		err := h.LoadTomlColors(*ruleFile)
+		if err != nil {
+			Fatalf("Unable to read rule file: %s", err)
+		}
+	}
+
 	// Process -c and -f, and also extract simple (inline) rules.
 
 	inputArgs, err := parseArgs(h, getopt.Args(), *execute || *readFiles, *argumentSeparator)
@@ -184,6 +289,9 @@ func main() {
 			Fatalf("Unable to read rule file: %s", err)
 		}
 	}
+	if err := h.CheckVars(); err != nil {
+		Fatalf("Invalid rules: %s", err)
+	}
 
 	util.Dump("Highlighter (all built up): ", h)
 
@@ -193,6 +301,11 @@ func main() {
 	}
 
 	// Main.
+	if !getopt.IsSet("line-buffered") {
+		*lineBuffered = isatty.IsTerminal(os.Stdout.Fd()) || (!*execute && !*readFiles && isatty.IsTerminal(os.Stdin.Fd()))
+	}
+	out = util.NewBufferedWriter(os.Stdout, *lineBuffered, outputFlushDelay)
+
 	if *readFiles {
 		for _, f := range inputArgs {
 			in, err := os.Open(f)
@@ -217,6 +330,9 @@ func main() {
 		}
 		doOnReader(h, in)
 	}
+	if err := out.Flush(); err != nil {
+		Fatalf("Unable to write output: %s", err)
+	}
 }
 
 func mayStartProfiler(outfile string) func() {
@@ -263,7 +379,7 @@ func startCommand(commandLine []string) (io.ReadCloser, func()) {
 func doOnReader(h *highlighter.Highlighter, rd io.ReadCloser) {
 	defer rd.Close()
 
-	err := h.NewRuntime(os.Stdout).ColorReader(rd /*callFinish*/, true)
+	err := h.NewRuntime(out).ColorReader(out.IdleFlushingReader(rd) /*callFinish*/, true)
 	if err != nil {
 		Fatalf("Unknown failure: %s", err)
 	}
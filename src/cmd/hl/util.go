@@ -6,6 +6,10 @@ import (
 )
 
 func Fatalf(format string, args ...interface{}) {
+	if out != nil {
+		out.Flush()
+	}
+
 	msg := fmt.Sprintf(Name+": "+format, args...)
 	fmt.Fprint(os.Stderr, msg)
 
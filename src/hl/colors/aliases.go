@@ -0,0 +1,54 @@
+package colors
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z_][\w.\-]*$`)
+
+// Aliases maps user defined color names to color specs.
+// Use NewAliases() to create a new instance.
+type Aliases struct {
+	aliases map[string]string
+}
+
+// NewAliases creates a new, empty Aliases.
+func NewAliases() *Aliases {
+	return &Aliases{aliases: map[string]string{}}
+}
+
+// Set defines a color alias. The spec may refer to other aliases.
+func (a *Aliases) Set(name, spec string) {
+	a.aliases[strings.ToLower(name)] = spec
+}
+
+// Has returns whether an alias is defined.
+func (a *Aliases) Has(name string) bool {
+	_, ok := a.aliases[strings.ToLower(name)]
+	return ok
+}
+
+// resolve resolves a color spec that's an alias name, recursively.
+// A spec that's not an alias is returned as is. a may be nil.
+func (a *Aliases) resolve(spec string) (string, error) {
+	if a == nil {
+		return spec, nil
+	}
+	var visited []string
+	for {
+		name := strings.ToLower(strings.TrimSpace(spec))
+		next, ok := a.aliases[name]
+		if !ok {
+			return spec, nil
+		}
+		for _, v := range visited {
+			if v == name {
+				return "", fmt.Errorf("circular color alias: %s -> %s", strings.Join(visited, " -> "), name)
+			}
+		}
+		visited = append(visited, name)
+		spec = next
+	}
+}
@@ -0,0 +1,52 @@
+package colors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromString_Aliases(t *testing.T) {
+	a := NewAliases()
+	a.Set("error", "bred/black")
+	a.Set("Fatal", "error")
+	a.Set("loop1", "loop2")
+	a.Set("loop2", "loop1")
+	a.Set("broken", "nosuchcolor")
+
+	tests := []struct {
+		source   string
+		expected string
+		noError  bool
+	}{
+		{`error`, `Colors{Attribute{b}, Color{index:1}/Color{index:0}}`, NoError},
+		{` ERROR `, `Colors{Attribute{b}, Color{index:1}/Color{index:0}}`, NoError},
+		{`fatal`, `Colors{Attribute{b}, Color{index:1}/Color{index:0}}`, NoError},
+		{`red`, `Colors{Color{index:1}/Color{none}}`, NoError},
+		{`loop1`, ``, Error},
+		{`broken`, ``, Error},
+		{`warning`, ``, Error},
+	}
+	for _, v := range tests {
+		c, err := a.FromString(v.source)
+		if err != nil {
+			if !v.noError {
+				continue
+			}
+			t.Errorf("Unexpected error '%s', source='%s'", err, v.source)
+			continue
+		}
+		if !v.noError {
+			t.Errorf("Error expected, but didn't happen, source='%s'", v.source)
+			continue
+		}
+		assert.Equal(t, v.expected, c.String(), "source='%s'", v.source)
+	}
+
+	assert.True(t, a.Has("FATAL"))
+	assert.False(t, NewAliases().Has("fatal"))
+
+	// Aliases aren't used without an Aliases.
+	_, err := FromString("error")
+	assert.Error(t, err)
+}
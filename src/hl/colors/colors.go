@@ -6,9 +6,10 @@ import (
 )
 
 const (
-	noColor     = 0
-	rgbColor    = 1
-	indexOffset = 128
+	noColor      = 0
+	rgbColor     = 1
+	paletteColor = 2
+	indexOffset  = 128
 )
 
 var (
@@ -19,12 +20,18 @@ var (
 	EmptyColors = Colors{}
 )
 
-// Color represents an index color, or a RGB888 color.
+// Color represents an index color, an xterm 256 palette color, or a RGB888 color.
+// A palette color also carries its (xterm default) RGB values, so it can be
+// converted to other forms when the terminal doesn't support the palette.
 type Color struct {
-	index uint8
-	r     uint8
-	g     uint8
-	b     uint8
+	index   uint8
+	r       uint8
+	g       uint8
+	b       uint8
+	palette uint8
+
+	// cube is set when the color was specified as a 6x6x6 cube color ("RGB" with digits 0-5).
+	cube bool
 }
 
 // String converts a color to a debug string.
@@ -35,6 +42,9 @@ func (c *Color) String() string {
 	if c.index >= indexOffset {
 		return fmt.Sprintf("Color{index:%d}", c.index-indexOffset)
 	}
+	if c.index == paletteColor {
+		return fmt.Sprintf("Color{palette:%d}", c.palette)
+	}
 	return fmt.Sprintf("Color{r:%d, g:%d, b:%d}", c.r, c.g, c.b)
 }
 
@@ -46,6 +56,12 @@ func NewIndexColor(index uint8) Color {
 	return Color{index: index + indexOffset}
 }
 
+// NewPaletteColor creates a new xterm 256 palette color [0-255].
+func NewPaletteColor(index uint8) Color {
+	r, g, b := PaletteRgb(index)
+	return Color{index: paletteColor, r: r, g: g, b: b, palette: index}
+}
+
 func color6to256(v uint8) uint8 {
 	if v > 5 {
 		panic(fmt.Sprintf("Color out of range: %d > 5", v))
@@ -54,7 +70,7 @@ func color6to256(v uint8) uint8 {
 }
 
 func newRgb216Color(r, g, b uint8) Color {
-	return Color{index: rgbColor, r: color6to256(r), g: color6to256(g), b: color6to256(b)}
+	return Color{index: rgbColor, r: color6to256(r), g: color6to256(g), b: color6to256(b), cube: true}
 }
 
 func newRgb888Color(r, g, b uint8) Color {
@@ -84,27 +100,49 @@ func (c *Color) IsRgb() bool {
 	return c.index == rgbColor
 }
 
-// Index returns the R value [0-255]. Panics if it's not a RGB color.
-func (c *Color) R() uint8 {
-	if !c.IsRgb() {
+// IsPalette returns whether it's an xterm 256 palette color or not.
+func (c *Color) IsPalette() bool {
+	return c.index == paletteColor
+}
+
+// PaletteIndex returns the palette index [0-255]. Panics if it's not a palette color.
+func (c *Color) PaletteIndex() uint8 {
+	if !c.IsPalette() {
+		panic("Not palette color.")
+	}
+	return c.palette
+}
+
+// CubeIndex returns the xterm 256 palette index of a color specified as a 6x6x6 cube color,
+// so that it can be rendered with the exact index rather than the nearest color.
+func (c *Color) CubeIndex() (uint8, bool) {
+	if !c.IsRgb() || !c.cube {
+		return 0, false
+	}
+	return CubeStart + 36*(c.r/51) + 6*(c.g/51) + c.b/51, true
+}
+
+func (c *Color) mustHaveRgb() {
+	if !c.IsRgb() && !c.IsPalette() {
 		panic("Not RGB color.")
 	}
+}
+
+// Index returns the R value [0-255]. Panics if it's not a RGB or palette color.
+func (c *Color) R() uint8 {
+	c.mustHaveRgb()
 	return c.r
 }
 
-// Index returns the G value [0-255]. Panics if it's not a RGB color.
+// Index returns the G value [0-255]. Panics if it's not a RGB or palette color.
 func (c *Color) G() uint8 {
-	if !c.IsRgb() {
-		panic("Not RGB color.")
-	}
+	c.mustHaveRgb()
 	return c.g
 }
 
-// Index returns the B value [0-255]. Panics if it's not a RGB color.
+// Index returns the B value [0-255]. Panics if it's not a RGB or palette color.
 func (c *Color) B() uint8 {
-	if !c.IsRgb() {
-		panic("Not RGB color.")
-	}
+	c.mustHaveRgb()
 	return c.b
 }
 
@@ -143,12 +181,13 @@ func (v Attribute) String() string {
 	return buffer.String()
 }
 
-// Colors is Attribute + a foreground color + a background color.
+// Colors is Attribute + a foreground color + a background color + an underline color.
 type Colors struct {
 	attrs Attribute
 
 	fg Color
 	bg Color
+	ul Color
 }
 
 // NewColors creates a new Colors.
@@ -156,6 +195,11 @@ func NewColors(fg, bg Color, attrs Attribute) Colors {
 	return Colors{attrs: attrs, fg: fg, bg: bg}
 }
 
+// NewColorsWithUnderline creates a new Colors with an underline color.
+func NewColorsWithUnderline(fg, bg, ul Color, attrs Attribute) Colors {
+	return Colors{attrs: attrs, fg: fg, bg: bg, ul: ul}
+}
+
 // Attributes return the attributes of a Colors.
 func (c *Colors) Attributes() Attribute {
 	return c.attrs
@@ -171,6 +215,11 @@ func (c *Colors) Bg() Color {
 	return c.bg
 }
 
+// Ul returns the underline Color of a Colors.
+func (c *Colors) Ul() Color {
+	return c.ul
+}
+
 func (c *Colors) String() string {
 	var buf bytes.Buffer
 
@@ -182,6 +231,10 @@ func (c *Colors) String() string {
 	buf.WriteString(c.fg.String())
 	buf.WriteString("/")
 	buf.WriteString(c.bg.String())
+	if !c.ul.IsNone() {
+		buf.WriteString(":")
+		buf.WriteString(c.ul.String())
+	}
 
 	buf.WriteString("}")
 
@@ -26,17 +26,34 @@ func TestNewRgb888Color(t *testing.T) {
 }
 
 func TestNewRgb216Color(t *testing.T) {
-	assert.Equal(t, Color{index: rgbColor, r: 0, g: 0, b: 0}, newRgb216Color(0, 0, 0))
-	assert.Equal(t, Color{index: rgbColor, r: 153, g: 0, b: 0}, newRgb216Color(3, 0, 0))
-	assert.Equal(t, Color{index: rgbColor, r: 0, g: 153, b: 0}, newRgb216Color(0, 3, 0))
-	assert.Equal(t, Color{index: rgbColor, r: 0, g: 0, b: 153}, newRgb216Color(0, 0, 3))
-	assert.Equal(t, Color{index: rgbColor, r: 255, g: 0, b: 0}, newRgb216Color(5, 0, 0))
-	assert.Equal(t, Color{index: rgbColor, r: 0, g: 255, b: 0}, newRgb216Color(0, 5, 0))
-	assert.Equal(t, Color{index: rgbColor, r: 0, g: 0, b: 255}, newRgb216Color(0, 0, 5))
+	assert.Equal(t, Color{index: rgbColor, r: 0, g: 0, b: 0, cube: true}, newRgb216Color(0, 0, 0))
+	assert.Equal(t, Color{index: rgbColor, r: 153, g: 0, b: 0, cube: true}, newRgb216Color(3, 0, 0))
+	assert.Equal(t, Color{index: rgbColor, r: 0, g: 153, b: 0, cube: true}, newRgb216Color(0, 3, 0))
+	assert.Equal(t, Color{index: rgbColor, r: 0, g: 0, b: 153, cube: true}, newRgb216Color(0, 0, 3))
+	assert.Equal(t, Color{index: rgbColor, r: 255, g: 0, b: 0, cube: true}, newRgb216Color(5, 0, 0))
+	assert.Equal(t, Color{index: rgbColor, r: 0, g: 255, b: 0, cube: true}, newRgb216Color(0, 5, 0))
+	assert.Equal(t, Color{index: rgbColor, r: 0, g: 0, b: 255, cube: true}, newRgb216Color(0, 0, 5))
 
 	assert.Panics(t, func() { newRgb216Color(6, 0, 0) })
 }
 
+func TestColor_CubeIndex(t *testing.T) {
+	check := func(c Color, expectedIndex uint8, expectedOk bool) {
+		index, ok := c.CubeIndex()
+		assert.Equal(t, expectedOk, ok, c.String())
+		assert.Equal(t, expectedIndex, index, c.String())
+	}
+	check(newRgb216Color(0, 0, 0), 16, true)
+	check(newRgb216Color(5, 0, 0), 196, true)
+	check(newRgb216Color(1, 2, 3), 67, true)
+	check(newRgb216Color(5, 5, 5), 231, true)
+
+	check(newRgb888Color(255, 0, 0), 0, false)
+	check(NewPaletteColor(196), 0, false)
+	check(NewIndexColor(1), 0, false)
+	check(NoColor, 0, false)
+}
+
 func TestColor_IsNone(t *testing.T) {
 	assert.Equal(t, true, a(NoColor).IsNone())
 
@@ -106,3 +123,41 @@ func TestColor_B(t *testing.T) {
 	assert.Equal(t, uint8(0), a(newRgb888Color(0, 0, 0)).B())
 	assert.Equal(t, uint8(255), a(newRgb888Color(0, 0, 255)).B())
 }
+
+func TestNewPaletteColor(t *testing.T) {
+	assert.Equal(t, Color{index: paletteColor, r: 0, g: 0, b: 0, palette: 0}, NewPaletteColor(0))
+	assert.Equal(t, Color{index: paletteColor, r: 255, g: 0, b: 0, palette: 9}, NewPaletteColor(9))
+	assert.Equal(t, Color{index: paletteColor, r: 255, g: 135, b: 0, palette: 208}, NewPaletteColor(208))
+	assert.Equal(t, Color{index: paletteColor, r: 8, g: 8, b: 8, palette: 232}, NewPaletteColor(232))
+	assert.Equal(t, Color{index: paletteColor, r: 238, g: 238, b: 238, palette: 255}, NewPaletteColor(255))
+}
+
+func TestColor_Palette(t *testing.T) {
+	c := NewPaletteColor(208)
+	assert.Equal(t, true, c.IsPalette())
+	assert.Equal(t, false, c.IsRgb())
+	assert.Equal(t, false, c.IsIndex())
+	assert.Equal(t, false, c.IsNone())
+	assert.Equal(t, uint8(208), c.PaletteIndex())
+	assert.Equal(t, uint8(255), c.R())
+	assert.Equal(t, uint8(135), c.G())
+	assert.Equal(t, uint8(0), c.B())
+
+	assert.Panics(t, func() { a(NoColor).PaletteIndex() })
+	assert.Panics(t, func() { a(NewIndexColor(0)).PaletteIndex() })
+	assert.Panics(t, func() { a(newRgb888Color(0, 0, 0)).PaletteIndex() })
+}
+
+func TestPaletteRgb(t *testing.T) {
+	check := func(index, r, g, b uint8) {
+		ar, ag, ab := PaletteRgb(index)
+		assert.Equal(t, []uint8{r, g, b}, []uint8{ar, ag, ab}, "index=%d", index)
+	}
+	check(1, 205, 0, 0)
+	check(15, 255, 255, 255)
+	check(16, 0, 0, 0)
+	check(21, 0, 0, 255)
+	check(196, 255, 0, 0)
+	check(231, 255, 255, 255)
+	check(244, 128, 128, 128)
+}
@@ -0,0 +1,140 @@
+package colors
+
+import "math"
+
+// HasRgb returns whether a color has RGB values, which is true for RGB colors and palette colors.
+func (c *Color) HasRgb() bool {
+	return c.IsRgb() || c.IsPalette()
+}
+
+func linearize(v uint8) float64 {
+	s := float64(v) / 255
+	if s <= 0.03928 {
+		return s / 12.92
+	}
+	return math.Pow((s+0.055)/1.055, 2.4)
+}
+
+// RelativeLuminance returns the WCAG relative luminance [0-1] of a color.
+// Panics if the color doesn't have RGB values.
+func RelativeLuminance(c Color) float64 {
+	return 0.2126*linearize(c.R()) + 0.7152*linearize(c.G()) + 0.0722*linearize(c.B())
+}
+
+// ContrastRatio returns the WCAG contrast ratio [1-21] between two colors.
+// Panics if either color doesn't have RGB values.
+func ContrastRatio(c1, c2 Color) float64 {
+	l1 := RelativeLuminance(c1)
+	l2 := RelativeLuminance(c2)
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+func rgbToHsl(r, g, b uint8) (h, s, l float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	max := math.Max(rf, math.Max(gf, bf))
+	min := math.Min(rf, math.Min(gf, bf))
+	l = (max + min) / 2
+	if max == min {
+		return 0, 0, l
+	}
+	d := max - min
+	if l > 0.5 {
+		s = d / (2 - max - min)
+	} else {
+		s = d / (max + min)
+	}
+	switch max {
+	case rf:
+		h = (gf - bf) / d
+		if gf < bf {
+			h += 6
+		}
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	return h / 6, s, l
+}
+
+func hslToRgb(h, s, l float64) (r, g, b uint8) {
+	if s == 0 {
+		v := uint8(math.Round(l * 255))
+		return v, v, v
+	}
+	hueToRgb := func(p, q, t float64) float64 {
+		if t < 0 {
+			t++
+		}
+		if t > 1 {
+			t--
+		}
+		switch {
+		case t < 1.0/6:
+			return p + (q-p)*6*t
+		case t < 1.0/2:
+			return q
+		case t < 2.0/3:
+			return p + (q-p)*(2.0/3-t)*6
+		}
+		return p
+	}
+	var q float64
+	if l < 0.5 {
+		q = l * (1 + s)
+	} else {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+	toByte := func(v float64) uint8 {
+		return uint8(math.Round(v * 255))
+	}
+	return toByte(hueToRgb(p, q, h+1.0/3)), toByte(hueToRgb(p, q, h)), toByte(hueToRgb(p, q, h-1.0/3))
+}
+
+// AdjustContrast returns fg with its lightness adjusted so that its contrast ratio
+// against bg is at least minRatio, keeping the hue and the saturation.
+// The foreground gets lighter or darker, whichever can give more contrast against bg.
+// fg is returned as is if it already has enough contrast, or either color doesn't have RGB values.
+func AdjustContrast(fg, bg Color, minRatio float64) Color {
+	if !fg.HasRgb() || !bg.HasRgb() || ContrastRatio(fg, bg) >= minRatio {
+		return fg
+	}
+	h, s, l := rgbToHsl(fg.R(), fg.G(), fg.B())
+
+	// Go towards white or black, whichever has more contrast against bg. It's not simply
+	// whether bg is darker than 0.5, e.g. white and black have the same contrast against
+	// a luminance of about 0.18.
+	lighter := ContrastRatio(newRgb888Color(255, 255, 255), bg) >= ContrastRatio(newRgb888Color(0, 0, 0), bg)
+	lo, hi := l, 1.0
+	if !lighter {
+		lo, hi = 0.0, l
+	}
+	// Binary search for the lightness closest to the original that satisfies the ratio.
+	best := newRgb888Color(hslToRgb(h, s, hi))
+	if !lighter {
+		best = newRgb888Color(hslToRgb(h, s, lo))
+	}
+	for i := 0; i < 16; i++ {
+		mid := (lo + hi) / 2
+		c := newRgb888Color(hslToRgb(h, s, mid))
+		if ContrastRatio(c, bg) >= minRatio {
+			best = c
+			if lighter {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		} else {
+			if lighter {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+	}
+	return best
+}
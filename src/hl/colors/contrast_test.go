@@ -0,0 +1,62 @@
+package colors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContrastRatio(t *testing.T) {
+	black := newRgb888Color(0, 0, 0)
+	white := newRgb888Color(255, 255, 255)
+
+	assert.InDelta(t, 21.0, ContrastRatio(black, white), 0.01)
+	assert.InDelta(t, 21.0, ContrastRatio(white, black), 0.01)
+	assert.InDelta(t, 1.0, ContrastRatio(white, white), 0.01)
+	assert.InDelta(t, 4.0, ContrastRatio(newRgb888Color(255, 0, 0), white), 0.01)
+	assert.InDelta(t, 21.0, ContrastRatio(NewPaletteColor(16), NewPaletteColor(231)), 0.01)
+}
+
+func TestHslRoundTrip(t *testing.T) {
+	for _, c := range [][3]uint8{{0, 0, 0}, {255, 255, 255}, {255, 0, 0}, {12, 200, 99}, {128, 64, 250}} {
+		h, s, l := rgbToHsl(c[0], c[1], c[2])
+		r, g, b := hslToRgb(h, s, l)
+		assert.Equal(t, c, [3]uint8{r, g, b})
+	}
+}
+
+func TestAdjustContrast(t *testing.T) {
+	darkBg := newRgb888Color(0, 0, 51)
+	lightBg := newRgb888Color(255, 255, 224)
+	darkBlue := newRgb888Color(0, 0, 153)
+	yellow := newRgb888Color(255, 255, 0)
+
+	// Already enough contrast.
+	assert.Equal(t, yellow, AdjustContrast(yellow, darkBg, 4.5))
+
+	// Gets lighter on a dark background.
+	c := AdjustContrast(darkBlue, darkBg, 4.5)
+	assert.GreaterOrEqual(t, ContrastRatio(c, darkBg), 4.5)
+	assert.Less(t, ContrastRatio(c, darkBg), 5.0)
+	assert.Greater(t, RelativeLuminance(c), RelativeLuminance(darkBlue))
+	assert.Equal(t, c.R(), c.G()) // Hue preserved.
+	assert.Less(t, c.R(), c.B())
+
+	// Gets darker on a light background.
+	c = AdjustContrast(yellow, lightBg, 4.5)
+	assert.GreaterOrEqual(t, ContrastRatio(c, lightBg), 4.5)
+	assert.Less(t, RelativeLuminance(c), RelativeLuminance(yellow))
+
+	// Gets darker on a mid-gray background, where white can't have enough contrast.
+	gray := newRgb888Color(0x80, 0x80, 0x80)
+	c = AdjustContrast(newRgb888Color(0x90, 0x90, 0x90), gray, 4.5)
+	assert.GreaterOrEqual(t, ContrastRatio(c, gray), 4.5)
+	assert.Less(t, RelativeLuminance(c), RelativeLuminance(gray))
+
+	// Can't be satisfied; goes all the way to white.
+	assert.Equal(t, newRgb888Color(255, 255, 255), AdjustContrast(newRgb888Color(128, 128, 128), newRgb888Color(100, 100, 100), 21))
+
+	// Colors without RGB values are left as is.
+	assert.Equal(t, NewIndexColor(4), AdjustContrast(NewIndexColor(4), darkBg, 4.5))
+	assert.Equal(t, darkBlue, AdjustContrast(darkBlue, NoColor, 4.5))
+}
@@ -0,0 +1,94 @@
+package colors
+
+import (
+	"math"
+	"sync"
+)
+
+// lab is a color in the CIELAB color space.
+type lab struct {
+	l, a, b float64
+}
+
+func (x lab) distance(y lab) float64 {
+	dl := x.l - y.l
+	da := x.a - y.a
+	db := x.b - y.b
+	return dl*dl + da*da + db*db
+}
+
+func labF(t float64) float64 {
+	if t > 216.0/24389.0 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27.0*t + 16) / 116
+}
+
+// rgbToLab converts a sRGB color into CIELAB, using the D65 white point.
+func rgbToLab(r, g, b uint8) lab {
+	lr := linearize(r)
+	lg := linearize(g)
+	lb := linearize(b)
+
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / 0.95047
+	y := 0.2126729*lr + 0.7151522*lg + 0.0721750*lb
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / 1.08883
+
+	fx := labF(x)
+	fy := labF(y)
+	fz := labF(z)
+
+	return lab{l: 116*fy - 16, a: 500 * (fx - fy), b: 200 * (fy - fz)}
+}
+
+var (
+	paletteLab [256]lab
+
+	nearest256Cache sync.Map // uint32 -> uint8
+	nearest16Cache  sync.Map // uint32 -> uint8
+)
+
+func init() {
+	for i := 0; i < 256; i++ {
+		paletteLab[i] = rgbToLab(PaletteRgb(uint8(i)))
+	}
+}
+
+func rgbKey(r, g, b uint8) uint32 {
+	return uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+}
+
+// nearestInRange returns the palette index in [start, end) perceptually closest to a RGB color.
+func nearestInRange(r, g, b uint8, start, end int) uint8 {
+	target := rgbToLab(r, g, b)
+	best := start
+	bestDistance := math.Inf(1)
+	for i := start; i < end; i++ {
+		if d := target.distance(paletteLab[i]); d < bestDistance {
+			best = i
+			bestDistance = d
+		}
+	}
+	return uint8(best)
+}
+
+func nearestCached(cache *sync.Map, r, g, b uint8, start, end int) uint8 {
+	key := rgbKey(r, g, b)
+	if v, ok := cache.Load(key); ok {
+		return v.(uint8)
+	}
+	ret := nearestInRange(r, g, b, start, end)
+	cache.Store(key, ret)
+	return ret
+}
+
+// Nearest256 returns the xterm 256 palette index that is perceptually closest to a RGB color.
+// The 16 ANSI colors are not considered, because terminals often customize them.
+func Nearest256(r, g, b uint8) uint8 {
+	return nearestCached(&nearest256Cache, r, g, b, CubeStart, 256)
+}
+
+// Nearest16 returns the ANSI color index [0-15] that is perceptually closest to a RGB color.
+func Nearest16(r, g, b uint8) uint8 {
+	return nearestCached(&nearest16Cache, r, g, b, 0, CubeStart)
+}
@@ -0,0 +1,67 @@
+package colors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearest256(t *testing.T) {
+	inputs := []struct {
+		rgb      uint32
+		expected uint8
+	}{
+		// Exact palette colors.
+		{0x000000, 16},
+		{0xffffff, 231},
+		{0xff0000, 196},
+		{0xff8700, 208},
+		{0x5f87af, 67},
+		{0x080808, 232},
+		{0xeeeeee, 255},
+
+		// Grays should use the grayscale ramp, not the cube.
+		{0x444444, 238},
+		{0x333333, 236},
+		{0x777777, 243},
+		{0xbbbbbb, 250},
+
+		// Other colors.
+		{0xff8000, 208},
+		{0x1e90ff, 33},
+		{0x800000, 88},
+		{0x008080, 30},
+	}
+	for _, v := range inputs {
+		r, g, b := uint8(v.rgb>>16), uint8(v.rgb>>8), uint8(v.rgb)
+		assert.Equal(t, v.expected, Nearest256(r, g, b), "%06x", v.rgb)
+
+		// Again, from the cache.
+		assert.Equal(t, v.expected, Nearest256(r, g, b), "%06x", v.rgb)
+	}
+}
+
+func TestNearest16(t *testing.T) {
+	inputs := []struct {
+		rgb      uint32
+		expected uint8
+	}{
+		{0x000000, 0},
+		{0xcd0000, 1},
+		{0x00ff00, 10},
+		{0x0000ff, 4},
+		{0x5c5cff, 12},
+		{0xffffff, 15},
+		{0x444444, 8},
+		{0x222222, 0},
+		{0x808080, 8},
+		{0xc0c0c0, 7},
+		{0x800000, 1},
+		{0xffff00, 11},
+		{0xc0c000, 3},
+	}
+	for _, v := range inputs {
+		r, g, b := uint8(v.rgb>>16), uint8(v.rgb>>8), uint8(v.rgb)
+		assert.Equal(t, v.expected, Nearest16(r, g, b), "%06x", v.rgb)
+	}
+}
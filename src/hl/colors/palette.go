@@ -0,0 +1,48 @@
+package colors
+
+var (
+	// ansiPalette is the xterm default for the 16 ANSI colors.
+	ansiPalette = [16][3]uint8{
+		{0, 0, 0},
+		{205, 0, 0},
+		{0, 205, 0},
+		{205, 205, 0},
+		{0, 0, 238},
+		{205, 0, 205},
+		{0, 205, 205},
+		{229, 229, 229},
+		{127, 127, 127},
+		{255, 0, 0},
+		{0, 255, 0},
+		{255, 255, 0},
+		{92, 92, 255},
+		{255, 0, 255},
+		{0, 255, 255},
+		{255, 255, 255},
+	}
+
+	// cubeLevels are the channel values of the xterm 6x6x6 color cube.
+	cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+)
+
+const (
+	// CubeStart is the palette index of the first color in the 6x6x6 color cube.
+	CubeStart = 16
+
+	// GrayStart is the palette index of the first color in the 24 step grayscale ramp.
+	GrayStart = 232
+)
+
+// PaletteRgb returns the RGB values of an xterm 256 color palette index.
+func PaletteRgb(index uint8) (r, g, b uint8) {
+	if index < CubeStart {
+		c := ansiPalette[index]
+		return c[0], c[1], c[2]
+	}
+	if index >= GrayStart {
+		v := 8 + 10*(index-GrayStart)
+		return v, v, v
+	}
+	i := index - CubeStart
+	return cubeLevels[i/36], cubeLevels[(i/6)%6], cubeLevels[i%6]
+}
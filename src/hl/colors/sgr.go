@@ -0,0 +1,157 @@
+package colors
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// NewRgbColor creates a new RGB888 color.
+func NewRgbColor(r, g, b uint8) Color {
+	return newRgb888Color(r, g, b)
+}
+
+// IsEmpty returns whether a Colors has no colors and no attributes.
+func (c *Colors) IsEmpty() bool {
+	return *c == EmptyColors
+}
+
+// sgrParams splits SGR parameters into numbers. Missing numbers are -1.
+func sgrParams(params []byte) []int {
+	ret := make([]int, 0, 8)
+	for _, p := range bytes.Split(params, []byte(";")) {
+		v, err := strconv.Atoi(string(p))
+		if err != nil {
+			v = -1
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+// parseExtendedColor parses the parameters after 38, 48 and 58, such as "5;N" and "2;R;G;B".
+// Returns the color and the number of parameters consumed.
+func parseExtendedColor(params []int) (Color, int) {
+	if len(params) >= 2 && params[0] == 5 {
+		if params[1] < 0 || params[1] > 255 {
+			return NoColor, 2
+		}
+		return NewPaletteColor(uint8(params[1])), 2
+	}
+	if len(params) >= 4 && params[0] == 2 {
+		for _, v := range params[1:4] {
+			if v < 0 || v > 255 {
+				return NoColor, 4
+			}
+		}
+		return newRgb888Color(uint8(params[1]), uint8(params[2]), uint8(params[3])), 4
+	}
+	return NoColor, len(params)
+}
+
+// parseSubParams parses a colon-separated parameter, such as "38:2::R:G:B" and "4:3".
+func parseSubParams(c *Colors, param []byte) {
+	sub := bytes.Split(param, []byte(":"))
+	code, err := strconv.Atoi(string(sub[0]))
+	if err != nil {
+		return
+	}
+	values := make([]int, 0, len(sub))
+	for _, s := range sub[1:] {
+		v, err := strconv.Atoi(string(s))
+		if err != nil {
+			v = -1
+		}
+		values = append(values, v)
+	}
+	switch code {
+	case 4:
+		// Underline styles, such as curly underlines. 4:0 means no underline.
+		if len(values) > 0 && values[0] == 0 {
+			c.attrs &^= Underline
+		} else {
+			c.attrs |= Underline
+		}
+	case 38, 48, 58:
+		// "38:2:R:G:B" and "38:2:ID:R:G:B", where ID is a color space ID, which may be empty.
+		if len(values) == 5 && values[0] == 2 {
+			values = append(values[:1], values[2:]...)
+		}
+		color, _ := parseExtendedColor(values)
+		switch code {
+		case 38:
+			c.fg = color
+		case 48:
+			c.bg = color
+		case 58:
+			c.ul = color
+		}
+	}
+}
+
+// ApplySgr applies the parameters of an SGR escape sequence (e.g. "1;31" of "ESC[1;31m")
+// to c, and returns the result. Unsupported parameters, such as blink, are ignored.
+func ApplySgr(c Colors, params []byte) Colors {
+	if bytes.IndexByte(params, ':') >= 0 {
+		for _, p := range bytes.Split(params, []byte(";")) {
+			if bytes.IndexByte(p, ':') >= 0 {
+				parseSubParams(&c, p)
+			} else {
+				c = ApplySgr(c, p)
+			}
+		}
+		return c
+	}
+
+	values := sgrParams(params)
+	for i := 0; i < len(values); i++ {
+		v := values[i]
+		switch {
+		case v <= 0:
+			c = EmptyColors
+		case v == 1:
+			c.attrs |= Intense
+		case v == 2:
+			c.attrs |= Faint
+		case v == 3:
+			c.attrs |= Italic
+		case v == 4:
+			c.attrs |= Underline
+		case v == 9:
+			c.attrs |= Strike
+		case v == 22:
+			c.attrs &^= Intense | Faint
+		case v == 23:
+			c.attrs &^= Italic
+		case v == 24:
+			c.attrs &^= Underline
+		case v == 29:
+			c.attrs &^= Strike
+		case 30 <= v && v <= 37:
+			c.fg = NewIndexColor(uint8(v - 30))
+		case v == 39:
+			c.fg = NoColor
+		case 40 <= v && v <= 47:
+			c.bg = NewIndexColor(uint8(v - 40))
+		case v == 49:
+			c.bg = NoColor
+		case v == 59:
+			c.ul = NoColor
+		case 90 <= v && v <= 97:
+			c.fg = NewPaletteColor(uint8(v - 90 + 8))
+		case 100 <= v && v <= 107:
+			c.bg = NewPaletteColor(uint8(v - 100 + 8))
+		case v == 38 || v == 48 || v == 58:
+			color, n := parseExtendedColor(values[i+1:])
+			i += n
+			switch v {
+			case 38:
+				c.fg = color
+			case 48:
+				c.bg = color
+			case 58:
+				c.ul = color
+			}
+		}
+	}
+	return c
+}
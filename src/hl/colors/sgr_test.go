@@ -0,0 +1,50 @@
+package colors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySgr(t *testing.T) {
+	red := NewIndexColor(1)
+	blue := NewIndexColor(4)
+	orange := NewPaletteColor(208)
+	rgb := NewRgbColor(1, 2, 3)
+
+	inputs := []struct {
+		base     Colors
+		params   string
+		expected Colors
+	}{
+		{EmptyColors, "", EmptyColors},
+		{NewColors(red, blue, Intense), "", EmptyColors},
+		{NewColors(red, blue, Intense), "0", EmptyColors},
+		{EmptyColors, "31", NewColors(red, NoColor, NoAttributes)},
+		{EmptyColors, "1;31;44", NewColors(red, blue, Intense)},
+		{EmptyColors, "01;31", NewColors(red, NoColor, Intense)},
+		{EmptyColors, "3;4;9;2", NewColors(NoColor, NoColor, Italic|Underline|Strike|Faint)},
+		{NewColors(red, blue, Intense|Faint|Italic|Underline|Strike), "22;23", NewColors(red, blue, Underline|Strike)},
+		{NewColors(red, blue, Underline|Strike), "24;29;39", NewColors(NoColor, blue, NoAttributes)},
+		{NewColors(red, blue, Intense), "49", NewColors(red, NoColor, Intense)},
+		{EmptyColors, "91;102", NewColors(NewPaletteColor(9), NewPaletteColor(10), NoAttributes)},
+		{EmptyColors, "38;5;208", NewColors(orange, NoColor, NoAttributes)},
+		{EmptyColors, "48;2;1;2;3;1", NewColors(NoColor, rgb, Intense)},
+		{EmptyColors, "58;5;208", NewColorsWithUnderline(NoColor, NoColor, orange, NoAttributes)},
+		{NewColorsWithUnderline(NoColor, NoColor, orange, NoAttributes), "59", EmptyColors},
+		{EmptyColors, "38:5:208", NewColors(orange, NoColor, NoAttributes)},
+		{EmptyColors, "38:2:1:2:3", NewColors(rgb, NoColor, NoAttributes)},
+		{EmptyColors, "38:2::1:2:3;1", NewColors(rgb, NoColor, Intense)},
+		{EmptyColors, "4:3", NewColors(NoColor, NoColor, Underline)},
+		{NewColors(NoColor, NoColor, Underline), "4:0", EmptyColors},
+
+		// Unsupported or broken parameters are ignored.
+		{EmptyColors, "5;7;31", NewColors(red, NoColor, NoAttributes)},
+		{EmptyColors, "38;5;256;1", NewColors(NoColor, NoColor, Intense)},
+		{EmptyColors, "38;5", EmptyColors},
+	}
+	for _, v := range inputs {
+		actual := ApplySgr(v.base, []byte(v.params))
+		assert.Equal(t, v.expected, actual, "%s + %q", v.base.String(), v.params)
+	}
+}
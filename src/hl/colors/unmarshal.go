@@ -2,21 +2,38 @@ package colors
 
 import (
 	"errors"
+	"fmt"
 	"github.com/omakoto/hl2/src/hl/util"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 var (
-	colorPat = `(?:(black|red|green|yellow|blue|magenta|cyan|white)|(\d{3})|([0-9a-f]{2}),?([0-9a-f]{2}),?([0-9a-f]{2}))`
-	colorsRe = regexp.MustCompile(`^(?i)\s*(?:([bifus]*)\s*` + colorPat + `)?\s*(?:\/\s*` + colorPat + `)?\s*$`)
+	basicColorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+	colorPat = `(?:(` + colorNamePat() + `)|(\d{3})|([0-9a-f]{2}),?([0-9a-f]{2}),?([0-9a-f]{2}))`
+	colorsRe = regexp.MustCompile(`^(?i)\s*([bifus]*)\s*(?:` + colorPat + `)?\s*(?:\/\s*` + colorPat + `)?\s*(?::\s*` + colorPat + `)?\s*$`)
 )
 
 // FromString parses a string into a Colors.
 func FromString(s string) (*Colors, error) {
+	return (*Aliases)(nil).FromString(s)
+}
+
+// FromString parses a string, which may be an alias name, into a Colors. a may be nil.
+func (a *Aliases) FromString(s string) (*Colors, error) {
+	spec, err := a.resolve(s)
+	if err != nil {
+		return nil, err
+	}
 	c := Colors{}
-	err := c.UnmarshalText([]byte(s))
+	err = c.UnmarshalText([]byte(spec))
 	if err != nil {
+		if aliasNameRe.MatchString(strings.TrimSpace(spec)) {
+			return nil, errors.New("Unknown color or alias name '" + strings.TrimSpace(spec) + "'")
+		}
 		return nil, err
 	}
 	return &c, nil
@@ -51,8 +68,19 @@ func (c *Colors) UnmarshalText(text []byte) error {
 	}
 	c.attrs = attrs
 
-	c.fg = parseColor(captures[2], captures[3], captures[4], captures[5], captures[6])
-	c.bg = parseColor(captures[7], captures[8], captures[9], captures[10], captures[11])
+	var err error
+	c.fg, err = parseColor(captures[2], captures[3], captures[4], captures[5], captures[6])
+	if err != nil {
+		return err
+	}
+	c.bg, err = parseColor(captures[7], captures[8], captures[9], captures[10], captures[11])
+	if err != nil {
+		return err
+	}
+	c.ul, err = parseColor(captures[12], captures[13], captures[14], captures[15], captures[16])
+	if err != nil {
+		return err
+	}
 
 	util.Debugf("Input='%s'\n", text)
 	util.Dump("Matches=", captures)
@@ -61,36 +89,70 @@ func (c *Colors) UnmarshalText(text []byte) error {
 	return nil
 }
 
-func parseColor(name, rgb216, r8, g8, b8 []byte) Color {
-	if name != nil {
-		if name[0] == 'r' { // red
-			return NewIndexColor(1)
-		}
-		if name[0] == 'g' { // green
-			return NewIndexColor(2)
-		}
-		if name[0] == 'y' { // yellow
-			return NewIndexColor(3)
+// colorNamePat returns a regex matching all the color names.
+// Longer names come first, so that e.g. "brightred" wins over "red".
+func colorNamePat() string {
+	names := make([]string, 0, len(cssColors)+len(basicColorNames)*2)
+	for _, n := range basicColorNames {
+		names = append(names, n, "bright"+n)
+	}
+	for n := range cssColors {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
 		}
-		if name[0] == 'm' { // magenta
-			return NewIndexColor(5)
+		return names[i] < names[j]
+	})
+	return `c\d{1,3}|gr[ae]y\d{1,2}|` + strings.Join(names, "|")
+}
+
+// parseColorName converts a color name into a Color.
+func parseColorName(name string) (Color, error) {
+	name = strings.ToLower(name)
+	for i, n := range basicColorNames {
+		if name == n {
+			return NewIndexColor(uint8(i)), nil
 		}
-		if name[0] == 'c' { // cyan
-			return NewIndexColor(6)
+		if name == "bright"+n {
+			return NewPaletteColor(uint8(i + 8)), nil
 		}
-		if name[0] == 'w' { // white
-			return NewIndexColor(7)
+	}
+	if v, ok := cssColors[name]; ok {
+		return newRgb888Color(uint8(v>>16), uint8(v>>8), uint8(v)), nil
+	}
+	if name[0] == 'c' {
+		index, err := strconv.Atoi(name[1:])
+		if err != nil || index > 255 {
+			return NoColor, fmt.Errorf("palette index out of range in '%s'", name)
 		}
-		if name[2] == 'a' { // black
-			return NewIndexColor(0)
+		return NewPaletteColor(uint8(index)), nil
+	}
+	if strings.HasPrefix(name, "gray") || strings.HasPrefix(name, "grey") {
+		level, err := strconv.Atoi(name[4:])
+		if err != nil || level > 23 {
+			return NoColor, fmt.Errorf("gray level out of range in '%s'", name)
 		}
-		return NewIndexColor(4) // blue
+		return NewPaletteColor(uint8(GrayStart + level)), nil
+	}
+	return NoColor, fmt.Errorf("unknown color name '%s'", name)
+}
+
+func parseColor(name, rgb216, r8, g8, b8 []byte) (Color, error) {
+	if name != nil {
+		return parseColorName(string(name))
 	}
 	if rgb216 != nil {
-		return newRgb216Color(rgb216[0]-'0', rgb216[1]-'0', rgb216[2]-'0')
+		for _, v := range rgb216 {
+			if v > '5' {
+				return NoColor, fmt.Errorf("216 color value out of range in '%s'", rgb216)
+			}
+		}
+		return newRgb216Color(rgb216[0]-'0', rgb216[1]-'0', rgb216[2]-'0'), nil
 	}
 	if r8 == nil {
-		return NoColor
+		return NoColor, nil
 	}
 	hexToDec := func(b byte) uint8 {
 		if '0' <= b && b <= '9' {
@@ -108,5 +170,5 @@ func parseColor(name, rgb216, r8, g8, b8 []byte) Color {
 		return uint8(hexToDec(v[0])*16 + hexToDec(v[1]))
 	}
 
-	return newRgb888Color(parseHex(r8), parseHex(g8), parseHex(b8))
+	return newRgb888Color(parseHex(r8), parseHex(g8), parseHex(b8)), nil
 }
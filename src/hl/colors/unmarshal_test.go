@@ -22,6 +22,26 @@ func TestColors_MarshalText(t *testing.T) {
 		{`/500`, `Colors{Color{none}/Color{r:255, g:0, b:0}}`, NoError},
 		{`/123`, `Colors{Color{none}/Color{r:51, g:102, b:153}}`, NoError},
 		{`ff0000`, `Colors{Color{r:255, g:0, b:0}/Color{none}}`, NoError},
+		{`u`, `Colors{Attribute{u}, Color{none}/Color{none}}`, NoError},
+		{`u:red`, `Colors{Attribute{u}, Color{none}/Color{none}:Color{index:1}}`, NoError},
+		{`ugreen/black:500`, `Colors{Attribute{u}, Color{index:2}/Color{index:0}:Color{r:255, g:0, b:0}}`, NoError},
+		{`:ff0000`, `Colors{Color{none}/Color{none}:Color{r:255, g:0, b:0}}`, NoError},
+		{`red:`, ``, Error},
+		{`600`, ``, Error},
+		{`c208`, `Colors{Color{palette:208}/Color{none}}`, NoError},
+		{`bC0/c255`, `Colors{Attribute{b}, Color{palette:0}/Color{palette:255}}`, NoError},
+		{`c256`, ``, Error},
+		{`brightred`, `Colors{Color{palette:9}/Color{none}}`, NoError},
+		{`bbrightwhite/brightblack`, `Colors{Attribute{b}, Color{palette:15}/Color{palette:8}}`, NoError},
+		{`gray0/grey23`, `Colors{Color{palette:232}/Color{palette:255}}`, NoError},
+		{`gray24`, ``, Error},
+		{`gray`, `Colors{Color{r:128, g:128, b:128}/Color{none}}`, NoError},
+		{`blue`, `Colors{Color{index:4}/Color{none}}`, NoError},
+		{`bblue`, `Colors{Attribute{b}, Color{index:4}/Color{none}}`, NoError},
+		{`bisque`, `Colors{Color{r:255, g:228, b:196}/Color{none}}`, NoError},
+		{`bisque/Navy`, `Colors{Color{r:255, g:228, b:196}/Color{r:0, g:0, b:128}}`, NoError},
+		{`uRebeccaPurple`, `Colors{Attribute{u}, Color{r:102, g:51, b:153}/Color{none}}`, NoError},
+		{`nosuchcolor`, ``, Error},
 	}
 	for _, v := range tests {
 		var c Colors
@@ -0,0 +1,116 @@
+package highlighter
+
+import (
+	"github.com/omakoto/hl2/src/hl/colors"
+	"github.com/omakoto/hl2/src/hl/term"
+)
+
+// InputColorMode specifies how to handle escape sequences in the input.
+type InputColorMode int
+
+const (
+	// InputColorsRaw treats escape sequences in the input as normal text.
+	InputColorsRaw InputColorMode = iota
+
+	// InputColorsKeep matches patterns against the text without escape sequences, and keeps
+	// the colors in the input, which rule colors are applied on top of.
+	InputColorsKeep
+
+	// InputColorsStrip matches patterns against the text without escape sequences, and
+	// drops the colors in the input.
+	InputColorsStrip
+)
+
+const escape = 0x1b
+
+// ansiParser removes CSI escape sequences from lines, and keeps track of the colors
+// set by SGR sequences.
+type ansiParser struct {
+	term term.Term
+
+	// current is the colors set by the last SGR sequence, which carries over to the next line.
+	current colors.Colors
+
+	// plain is the last line without escape sequences.
+	plain []byte
+
+	// base has the input colors for each byte in plain. nil means no colors.
+	base []*term.RenderedColors
+
+	rendered map[colors.Colors]*term.RenderedColors
+}
+
+func newAnsiParser(t term.Term) *ansiParser {
+	return &ansiParser{
+		term:     t,
+		plain:    make([]byte, 0, 4096),
+		base:     make([]*term.RenderedColors, 0, 4096),
+		rendered: make(map[colors.Colors]*term.RenderedColors),
+	}
+}
+
+func (p *ansiParser) render(c colors.Colors) *term.RenderedColors {
+	if c.IsEmpty() {
+		return nil
+	}
+	if r, ok := p.rendered[c]; ok {
+		return r
+	}
+	r := term.NewRenderedColors(p.term, &c)
+	p.rendered[c] = r
+	return r
+}
+
+// findCsiEnd returns the index of the final byte of a CSI sequence starting at b[start],
+// which points at the byte after "ESC [", or -1 if the sequence isn't terminated.
+func findCsiEnd(b []byte, start int) int {
+	for i := start; i < len(b); i++ {
+		ch := b[i]
+		if 0x40 <= ch && ch <= 0x7e {
+			return i
+		}
+		if ch < 0x20 || ch > 0x3f {
+			// Not a parameter byte (0x30-0x3f) or an intermediate byte (0x20-0x2f).
+			return -1
+		}
+	}
+	return -1
+}
+
+// isSgr returns whether CSI parameters and a final byte make an SGR sequence.
+func isSgr(params []byte, final byte) bool {
+	if final != 'm' {
+		return false
+	}
+	for _, ch := range params {
+		if !(('0' <= ch && ch <= '9') || ch == ';' || ch == ':') {
+			// Private parameters or intermediate bytes.
+			return false
+		}
+	}
+	return true
+}
+
+// parse removes CSI sequences from a line, and sets plain and base.
+// SGR sequences update the current colors, and other CSI sequences are simply removed.
+func (p *ansiParser) parse(b []byte) {
+	p.plain = p.plain[:0]
+	p.base = p.base[:0]
+
+	currentRendered := p.render(p.current)
+	for i := 0; i < len(b); i++ {
+		if b[i] == escape && i+1 < len(b) && b[i+1] == '[' {
+			end := findCsiEnd(b, i+2)
+			if end >= 0 {
+				if params := b[i+2 : end]; isSgr(params, b[end]) {
+					p.current = colors.ApplySgr(p.current, params)
+					currentRendered = p.render(p.current)
+				}
+				i = end
+				continue
+			}
+		}
+		p.plain = append(p.plain, b[i])
+		p.base = append(p.base, currentRendered)
+	}
+}
@@ -0,0 +1,47 @@
+package highlighter
+
+import (
+	"fmt"
+)
+
+// ColorSpec is a color spec in a TOML file, which is either a single string,
+// or a table with "dark" and "light" variants, one of which is chosen
+// depending on the terminal background.
+type ColorSpec struct {
+	Dark  string
+	Light string
+}
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (c *ColorSpec) UnmarshalTOML(v interface{}) error {
+	switch value := v.(type) {
+	case string:
+		c.Dark = value
+		c.Light = value
+		return nil
+	case map[string]interface{}:
+		for k, variant := range value {
+			s, ok := variant.(string)
+			if !ok {
+				return fmt.Errorf("color variant '%s' must be a string", k)
+			}
+			switch k {
+			case "dark":
+				c.Dark = s
+			case "light":
+				c.Light = s
+			default:
+				return fmt.Errorf("unknown color variant '%s'; must be dark or light", k)
+			}
+		}
+		// If only one variant is given, use it for both.
+		if c.Dark == "" {
+			c.Dark = c.Light
+		}
+		if c.Light == "" {
+			c.Light = c.Dark
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid color spec %v; must be a string or a table", v)
+}
@@ -0,0 +1,169 @@
+package highlighter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type unitKind int
+
+const (
+	noUnit unitKind = iota
+	timeUnit
+	sizeUnit
+)
+
+type unit struct {
+	kind  unitKind
+	scale float64
+}
+
+// units are the units that numbers in conditions and captures can have, in lowercase.
+// Times are in seconds, and sizes are in bytes, in powers of 1024. "m" is minutes, as in Go's
+// durations, so sizes don't have single-letter units.
+var units = map[string]unit{
+	"ns":  {timeUnit, 1e-9},
+	"us":  {timeUnit, 1e-6},
+	"µs":  {timeUnit, 1e-6}, // U+00B5 MICRO SIGN
+	"μs":  {timeUnit, 1e-6}, // U+03BC GREEK SMALL LETTER MU
+	"ms":  {timeUnit, 1e-3},
+	"s":   {timeUnit, 1},
+	"sec": {timeUnit, 1},
+	"m":   {timeUnit, 60},
+	"min": {timeUnit, 60},
+	"h":   {timeUnit, 3600},
+
+	"b":   {sizeUnit, 1},
+	"kb":  {sizeUnit, 1 << 10},
+	"kib": {sizeUnit, 1 << 10},
+	"mb":  {sizeUnit, 1 << 20},
+	"mib": {sizeUnit, 1 << 20},
+	"gb":  {sizeUnit, 1 << 30},
+	"gib": {sizeUnit, 1 << 30},
+	"tb":  {sizeUnit, 1 << 40},
+	"tib": {sizeUnit, 1 << 40},
+}
+
+// quantity is a number with an optional unit.
+type quantity struct {
+	value float64
+	unit  unit
+}
+
+var quantityRe = regexp.MustCompile(`^\s*([-+]?(?:\d+(?:\.\d*)?|\.\d+)(?:[eE][-+]?\d+)?)\s*(\pL*)\s*$`)
+
+// parseQuantity parses a number with an optional unit, such as "250", "1.5s" or "10 MB",
+// or a Go-style duration, such as "1m30s".
+func parseQuantity(s string) (quantity, bool) {
+	m := quantityRe.FindStringSubmatch(s)
+	if m == nil {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return quantity{}, false
+		}
+		return quantity{value: d.Seconds(), unit: units["s"]}, true
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return quantity{}, false
+	}
+	q := quantity{value: v}
+	if m[2] != "" {
+		u, ok := units[strings.ToLower(m[2])]
+		if !ok {
+			return quantity{}, false
+		}
+		q.unit = u
+	}
+	return q, true
+}
+
+// compare compares two quantities, and returns -1, 0 or 1. If either has no unit, the numbers
+// are compared as-is. ok is false if they have different kinds of units, e.g. a time and a size.
+func (q quantity) compare(o quantity) (result int, ok bool) {
+	a, b := q.value, o.value
+	if q.unit.kind != noUnit && o.unit.kind != noUnit {
+		if q.unit.kind != o.unit.kind {
+			return 0, false
+		}
+		a *= q.unit.scale
+		b *= o.unit.scale
+	}
+	switch {
+	case a < b:
+		return -1, true
+	case a > b:
+		return 1, true
+	}
+	return 0, true
+}
+
+// condition is a numeric comparison of a group of a match, such as "$1 > 250ms".
+type condition struct {
+	group     int
+	op        string
+	threshold quantity
+}
+
+var conditionRe = regexp.MustCompile(`^\s*\$(\w+|\{[^}]*\})\s*(<=|>=|==|!=|<|>)\s*(.*?)\s*$`)
+
+// parseCondition parses a condition. names are the group names of the pattern.
+func parseCondition(s string, names []string) (*condition, error) {
+	m := conditionRe.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid condition '%s'; must be like '$1 > 250ms'", s)
+	}
+	ref := strings.TrimSuffix(strings.TrimPrefix(m[1], "{"), "}")
+	group, err := findGroup(ref, names)
+	if err != nil {
+		return nil, fmt.Errorf("%s in condition '%s'", err, s)
+	}
+	c, err := newCondition(group, m[2], m[3])
+	if err != nil {
+		return nil, fmt.Errorf("%s in condition '%s'", err, s)
+	}
+	return c, nil
+}
+
+func newCondition(group int, op, threshold string) (*condition, error) {
+	q, ok := parseQuantity(threshold)
+	if !ok {
+		return nil, fmt.Errorf("invalid number '%s'", threshold)
+	}
+	return &condition{group: group, op: op, threshold: q}, nil
+}
+
+// eval returns whether a match meets the condition. indexes is an element of the result of
+// Matcher.FindAllSubmatchIndex(). Groups that aren't numbers never meet conditions.
+func (c *condition) eval(target []byte, indexes []int) bool {
+	start, end := indexes[c.group*2], indexes[c.group*2+1]
+	if start < 0 {
+		return false
+	}
+	q, ok := parseQuantity(string(target[start:end]))
+	if !ok {
+		return false
+	}
+	cmp, ok := q.compare(c.threshold)
+	if !ok {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package highlighter
+
+import (
+	"fmt"
+
+	"github.com/omakoto/hl2/src/hl/matcher"
+)
+
+// defaultDelimiter is the delimiter for rules with "field" but without "delimiter".
+const defaultDelimiter = `\s+`
+
+// fieldSplitter splits lines into fields, for rules with "field". Rules with the same
+// delimiter share the same fieldSplitter, so each line is split only once per delimiter.
+type fieldSplitter struct {
+	delimiter matcher.Matcher
+
+	// trim makes leading and trailing delimiters ignored, like awk does with whitespace.
+	trim bool
+}
+
+// getFieldSplitter returns the fieldSplitter for a delimiter pattern, creating it if needed.
+func (h *Highlighter) getFieldSplitter(delimiter string) (*fieldSplitter, error) {
+	if delimiter == "" {
+		delimiter = defaultDelimiter
+	}
+	if s, ok := h.fieldSplitters[delimiter]; ok {
+		return s, nil
+	}
+	m, err := matcher.Compile(delimiter, matcher.NoFlags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delimiter '%s': %s", delimiter, err)
+	}
+	s := &fieldSplitter{delimiter: m, trim: delimiter == defaultDelimiter}
+	if h.fieldSplitters == nil {
+		h.fieldSplitters = make(map[string]*fieldSplitter)
+	}
+	h.fieldSplitters[delimiter] = s
+	return s, nil
+}
+
+// split appends the start and end offsets of the fields in a line to bounds.
+func (s *fieldSplitter) split(target *matcher.Target, bounds []int) []int {
+	b := target.Bytes()
+	start := 0
+	for _, m := range s.delimiter.FindAllSubmatchIndex(target) {
+		if m[0] == m[1] {
+			continue
+		}
+		if !(s.trim && m[0] == 0) {
+			bounds = append(bounds, start, m[0])
+		}
+		start = m[1]
+	}
+	if !(s.trim && start == len(b)) {
+		bounds = append(bounds, start, len(b))
+	}
+	return bounds
+}
+
+// fieldBounds caches the result of fieldSplitter.split() for the current line.
+type fieldBounds struct {
+	lineNo int
+	bounds []int
+}
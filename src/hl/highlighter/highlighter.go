@@ -2,6 +2,7 @@ package highlighter
 
 import (
 	"fmt"
+	"github.com/omakoto/hl2/src/hl/colors"
 	"github.com/omakoto/hl2/src/hl/matcher"
 	"github.com/omakoto/hl2/src/hl/term"
 	"github.com/omakoto/hl2/src/hl/util"
@@ -12,26 +13,52 @@ import (
 type Highlighter struct {
 	term term.Term
 
+	// aliases has the color aliases from the theme and the rule file.
+	aliases *colors.Aliases
+
+	background term.Background
+
 	ignoreCase   bool
+	fixedStrings bool
+	wordRegexp   bool
 	defaultHide  bool
 	noSkipMarker bool
 
 	defaultBefore int
 	defaultAfter  int
 
+	minContrast float64
+
+	inputColors InputColorMode
+	sanitize    bool
+
+	disableTimedOutRules bool
+
+	maxStateDepth     int
+	rangeTimeoutLines int
+
 	rules []*Rule
+
+	// states has the defaults for the lines in each state, which override the global ones.
+	states map[string]*stateDefaults
+
+	// fieldSplitters has the fieldSplitter for each delimiter pattern.
+	fieldSplitters map[string]*fieldSplitter
 }
 
+// DefaultMaxStateDepth is the default maximum number of states saved by push_state.
+const DefaultMaxStateDepth = 32
+
 // NewHighlighter creates a new Highlighter instance with the auto-detected Term.
 func NewHighlighter() *Highlighter {
-	h := &Highlighter{}
+	h := &Highlighter{aliases: colors.NewAliases(), maxStateDepth: DefaultMaxStateDepth}
 	h.term = term.NewDefaultTerm()
 	return h
 }
 
 // NewHighlighter creates a new Highlighter instance with a given Term.
 func NewHighlighterWithTerm(t term.Term) *Highlighter {
-	h := &Highlighter{}
+	h := &Highlighter{aliases: colors.NewAliases(), maxStateDepth: DefaultMaxStateDepth}
 	h.term = t
 	return h
 }
@@ -40,6 +67,30 @@ func (h *Highlighter) Term() term.Term {
 	return h.term
 }
 
+// Background returns the terminal background, detecting it if it hasn't been set.
+func (h *Highlighter) Background() term.Background {
+	if h.background == term.UnknownBackground {
+		h.background = term.DetectBackground()
+		util.Debugf("Detected background: %s\n", h.background)
+	}
+	return h.background
+}
+
+// SetBackground sets the terminal background. Use UnknownBackground to auto-detect it.
+func (h *Highlighter) SetBackground(background term.Background) {
+	h.background = background
+}
+
+func (h *Highlighter) resolveColorSpec(c ColorSpec) string {
+	if c.Dark == c.Light {
+		return c.Dark
+	}
+	if h.Background() == term.LightBackground {
+		return c.Light
+	}
+	return c.Dark
+}
+
 func (h *Highlighter) IgnoreCase() bool {
 	return h.ignoreCase
 }
@@ -48,11 +99,46 @@ func (h *Highlighter) SetIgnoreCase(ignoreCase bool) {
 	h.ignoreCase = ignoreCase
 }
 
+func (h *Highlighter) FixedStrings() bool {
+	return h.fixedStrings
+}
+
+// SetFixedStrings makes all the patterns fixed strings, as if they had the '{=}' prefix.
+func (h *Highlighter) SetFixedStrings(fixedStrings bool) {
+	h.fixedStrings = fixedStrings
+}
+
+func (h *Highlighter) WordRegexp() bool {
+	return h.wordRegexp
+}
+
+// SetWordRegexp makes all the patterns match only whole words, as if they had the '{w}' prefix.
+func (h *Highlighter) SetWordRegexp(wordRegexp bool) {
+	h.wordRegexp = wordRegexp
+}
+
 func (h *Highlighter) MatcherFlags() matcher.Flags {
+	flags := matcher.NoFlags
 	if h.ignoreCase {
-		return matcher.IgnoreCase
+		flags |= matcher.IgnoreCase
 	}
-	return matcher.NoFlags
+	if h.fixedStrings {
+		flags |= matcher.FixedString
+	}
+	if h.wordRegexp {
+		flags |= matcher.WholeWord
+	}
+	return flags
+}
+
+func (h *Highlighter) DisableTimedOutRules() bool {
+	return h.disableTimedOutRules
+}
+
+// SetDisableTimedOutRules makes a rule disabled for the rest of the input once its match
+// times out. See matcher.MatchTimeout.
+func (h *Highlighter) SetDisableTimedOutRules(disable bool) {
+	h.disableTimedOutRules = disable
 }
 
 func (h *Highlighter) DefaultHide() bool {
@@ -87,6 +173,91 @@ func (h *Highlighter) SetDefaultBefore(defaultBefore int) {
 	h.defaultBefore = defaultBefore
 }
 
+func (h *Highlighter) MaxStateDepth() int {
+	return h.maxStateDepth
+}
+
+// SetMaxStateDepth sets the maximum number of states saved by push_state. When a push
+// exceeds it, the oldest saved state is dropped.
+func (h *Highlighter) SetMaxStateDepth(maxStateDepth int) {
+	h.maxStateDepth = maxStateDepth
+}
+
+func (h *Highlighter) RangeTimeoutLines() int {
+	return h.rangeTimeoutLines
+}
+
+// SetRangeTimeoutLines makes the ranges added after it end after the given number of lines
+// without the end pattern. 0 disables the timeout.
+func (h *Highlighter) SetRangeTimeoutLines(rangeTimeoutLines int) {
+	h.rangeTimeoutLines = rangeTimeoutLines
+}
+
+func (h *Highlighter) MinContrast() float64 {
+	return h.minContrast
+}
+
+// SetMinContrast sets the minimum contrast ratio between foreground and background colors.
+// 0 disables the adjustment.
+func (h *Highlighter) SetMinContrast(minContrast float64) {
+	h.minContrast = minContrast
+}
+
+func (h *Highlighter) InputColors() InputColorMode {
+	return h.inputColors
+}
+
+// SetInputColors sets how to handle escape sequences in the input.
+func (h *Highlighter) SetInputColors(inputColors InputColorMode) {
+	h.inputColors = inputColors
+}
+
+func (h *Highlighter) Sanitize() bool {
+	return h.sanitize
+}
+
+// SetSanitize sets whether to make control characters and escape sequences other than
+// SGR sequences in the input visible.
+func (h *Highlighter) SetSanitize(sanitize bool) {
+	h.sanitize = sanitize
+}
+
+// stateDefaults are the defaults for the lines in a state, i.e. the lines that end in it after
+// the transitions.
+type stateDefaults struct {
+	// hide is whether to hide the lines by default, or nil to use the global default.
+	hide *bool
+
+	// before and after are the minimum numbers of context lines around the lines shown.
+	before int
+	after  int
+
+	// lineColors are applied to the lines under the colors of the rules.
+	lineColors *term.RenderedColors
+}
+
+// SetStateDefaults sets the defaults for the lines in a state: whether to hide them (nil for
+// the global default), the minimum numbers of context lines before and after the lines shown,
+// and the line color.
+func (h *Highlighter) SetStateDefaults(state string, hide *bool, before, after int, lineColorsStr string) error {
+	if before < 0 || after < 0 {
+		return fmt.Errorf("invalid before/after for state '%s'; must be 0 or greater", state)
+	}
+	d := &stateDefaults{hide: hide, before: before, after: after}
+	if lineColorsStr != "" {
+		c, err := h.aliases.FromString(lineColorsStr)
+		if err != nil {
+			return err
+		}
+		d.lineColors = term.NewRenderedColors(h.Term(), c)
+	}
+	if h.states == nil {
+		h.states = make(map[string]*stateDefaults)
+	}
+	h.states[state] = d
+	return nil
+}
+
 func (h *Highlighter) getRules() []*Rule {
 	if h.rules == nil {
 		h.rules = make([]*Rule, 0)
@@ -98,6 +269,17 @@ func (h *Highlighter) LoadToml(ruleFile string) error {
 	return h.parseTomlFile(ruleFile)
 }
 
+// LoadTomlColors loads only the color aliases from a rule file, so rules added before
+// LoadToml() can use them too.
+func (h *Highlighter) LoadTomlColors(ruleFile string) error {
+	return h.parseTomlColors(ruleFile)
+}
+
+// LoadTheme loads color aliases from a theme file. Load a theme before rules that use it.
+func (h *Highlighter) LoadTheme(theme string) error {
+	return h.parseThemeFile(theme)
+}
+
 func (h *Highlighter) addRule(r *Rule) {
 	h.rules = append(h.getRules(), r)
 }
@@ -148,16 +330,21 @@ func (h *Highlighter) AddSimpleRangeRules(patternStart, colorsStart, patternEnd,
 
 	// Add a rule to show all lines between start and end.
 	intermediate := newRule(h)
-	m, _ := matcher.Compile("^", h.MatcherFlags())
+	m, _ := matcher.Compile("^", matcher.NoFlags)
 	intermediate.matcher = m
 	intermediate.SetStates([]string{implicitState})
 	intermediate.SetShow(true)
+	intermediate.rangeBody = true
 	h.addRule(intermediate)
 
 	// Start rule.
 	start.before = h.defaultBefore
 	start.SetNextState(implicitState)
 	start.SetShow(true)
+	if h.rangeTimeoutLines > 0 {
+		fallback := InitialState
+		start.stateTimeout = &stateTimeout{lines: h.rangeTimeoutLines, fallback: &fallback}
+	}
 	h.addRule(start)
 
 	return nil
@@ -0,0 +1,108 @@
+package highlighter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// linkPart is either a literal string, or a reference to a group.
+type linkPart struct {
+	literal string
+	group   int // -1 for a literal
+}
+
+// linkTemplate is a parsed "link" rule field, such as "https://bugs/$1".
+type linkTemplate struct {
+	parts []linkPart
+}
+
+// findGroup returns the group number referred to by a "$" reference, which is either a
+// number or a group name.
+func findGroup(ref string, names []string) (int, error) {
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 0 || n >= len(names) {
+			return 0, fmt.Errorf("group $%s doesn't exist", ref)
+		}
+		return n, nil
+	}
+	for i, name := range names {
+		if name != "" && name == ref {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("group ${%s} doesn't exist", ref)
+}
+
+// parseLinkTemplate parses a link template, which can refer to groups with $N, ${N} and
+// ${name}. Use "$$" for a literal "$". names are the group names of the pattern, and field is
+// the name of the rule field for error messages.
+func parseLinkTemplate(template string, names []string, field string) (*linkTemplate, error) {
+	ret := &linkTemplate{}
+	var literal strings.Builder
+
+	flushLiteral := func() {
+		if literal.Len() > 0 {
+			ret.parts = append(ret.parts, linkPart{literal: literal.String(), group: -1})
+			literal.Reset()
+		}
+	}
+
+	for i := 0; i < len(template); i++ {
+		ch := template[i]
+		if ch != '$' {
+			literal.WriteByte(ch)
+			continue
+		}
+		i++
+		if i >= len(template) {
+			return nil, fmt.Errorf("%s terminated with '$' in '%s'", field, template)
+		}
+		var ref string
+		switch {
+		case template[i] == '$':
+			literal.WriteByte('$')
+			continue
+		case template[i] == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated '${' in '%s'", template)
+			}
+			ref = template[i+1 : i+end]
+			i += end
+		case '0' <= template[i] && template[i] <= '9':
+			start := i
+			for i+1 < len(template) && '0' <= template[i+1] && template[i+1] <= '9' {
+				i++
+			}
+			ref = template[start : i+1]
+		default:
+			return nil, fmt.Errorf("invalid '$' in '%s'; use '$$' for '$'", template)
+		}
+		group, err := findGroup(ref, names)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s '%s'", err, field, template)
+		}
+		flushLiteral()
+		ret.parts = append(ret.parts, linkPart{group: group})
+	}
+	flushLiteral()
+	return ret, nil
+}
+
+// expand returns the URL for a match. indexes is an element of the result of
+// Matcher.FindAllSubmatchIndex().
+func (t *linkTemplate) expand(target []byte, indexes []int) string {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.group < 0 {
+			b.WriteString(p.literal)
+			continue
+		}
+		start, end := indexes[p.group*2], indexes[p.group*2+1]
+		if start >= 0 {
+			b.Write(target[start:end])
+		}
+	}
+	return b.String()
+}
@@ -1,6 +1,8 @@
 package highlighter
 
 import (
+	"errors"
+	"fmt"
 	"github.com/omakoto/hl2/src/hl/colors"
 	"github.com/omakoto/hl2/src/hl/matcher"
 	"github.com/omakoto/hl2/src/hl/term"
@@ -23,11 +25,32 @@ func newDecorativeLine(h *Highlighter, marker string, colors *colors.Colors) *de
 	}
 }
 
+// stateTimeout reverts a state after a number of lines in which no rule for the state matched.
+type stateTimeout struct {
+	lines int
+
+	// fallback is the state to revert to. If it's nil, the state reverts as pop_state does.
+	fallback *string
+
+	// line is written before the line on which the state timed out, if it's shown.
+	line *decorativeLine
+}
+
 type Rule struct {
 	highlighter *Highlighter
 
-	matcher    matcher.Matcher
-	preMatcher matcher.Matcher
+	matcher matcher.Matcher
+
+	// The rule only applies to lines that match all of when, at least one of whenAny (if any),
+	// and none of unless.
+	when    []matcher.Matcher
+	whenAny []matcher.Matcher
+	unless  []matcher.Matcher
+
+	// field is the 1-based index of the field that the pattern is matched against, or 0 to
+	// match against the entire line.
+	field         int
+	fieldSplitter *fieldSplitter
 
 	after  int
 	before int
@@ -42,8 +65,25 @@ type Rule struct {
 	preLine  *decorativeLine
 	postLine *decorativeLine
 
+	link *linkTemplate
+
+	// conditions are the numeric conditions that matches must meet.
+	conditions []*condition
+
+	// vars are the state variables set when the rule matches.
+	vars []varAssignment
+
 	states    []string
 	nextState string
+	pushState string
+	popState  bool
+
+	// stateTimeout is the timeout of the state that the rule transitions into.
+	stateTimeout *stateTimeout
+
+	// rangeBody is set on the rule that shows the lines in a range made by
+	// AddSimpleRangeRules. It matches every line, so it doesn't keep the state from timing out.
+	rangeBody bool
 }
 
 func newRule(h *Highlighter) *Rule {
@@ -63,7 +103,7 @@ func (r *Rule) isForState(state string) bool {
 }
 
 func (r *Rule) SetMatcherString(pattern string) error {
-	m, err := matcher.Compile(pattern, r.highlighter.MatcherFlags())
+	m, err := compilePattern(pattern, r.highlighter.MatcherFlags())
 	if err != nil {
 		return err
 	}
@@ -71,12 +111,64 @@ func (r *Rule) SetMatcherString(pattern string) error {
 	return nil
 }
 
+// SetPreMatcherString sets a pattern that lines must match for the rule to apply,
+// replacing the ones added with AddWhenString().
 func (r *Rule) SetPreMatcherString(pattern string) error {
-	m, err := matcher.Compile(pattern, r.highlighter.MatcherFlags())
+	r.when = nil
+	return r.AddWhenString(pattern)
+}
+
+// AddWhenString adds a pattern that lines must match for the rule to apply.
+func (r *Rule) AddWhenString(pattern string) error {
+	m, err := compilePattern(pattern, r.highlighter.MatcherFlags())
 	if err != nil {
 		return err
 	}
-	r.preMatcher = m
+	r.when = append(r.when, m)
+	return nil
+}
+
+// AddWhenAnyString adds a pattern to the ones at least one of which lines must match for the
+// rule to apply.
+func (r *Rule) AddWhenAnyString(pattern string) error {
+	m, err := compilePattern(pattern, r.highlighter.MatcherFlags())
+	if err != nil {
+		return err
+	}
+	r.whenAny = append(r.whenAny, m)
+	return nil
+}
+
+// AddUnlessString adds a pattern that lines must not match for the rule to apply.
+func (r *Rule) AddUnlessString(pattern string) error {
+	m, err := compilePattern(pattern, r.highlighter.MatcherFlags())
+	if err != nil {
+		return err
+	}
+	r.unless = append(r.unless, m)
+	return nil
+}
+
+// SetField makes the pattern matched only against the n-th field (1-based) of a line,
+// separated by a delimiter pattern, which defaults to whitespace. 0 means the entire line.
+func (r *Rule) SetField(n int, delimiter string) error {
+	if n < 0 {
+		return fmt.Errorf("invalid field %d; must be 1 or greater", n)
+	}
+	if n == 0 {
+		if delimiter != "" {
+			return errors.New("delimiter requires field")
+		}
+		r.field = 0
+		r.fieldSplitter = nil
+		return nil
+	}
+	s, err := r.highlighter.getFieldSplitter(delimiter)
+	if err != nil {
+		return err
+	}
+	r.field = n
+	r.fieldSplitter = s
 	return nil
 }
 
@@ -108,8 +200,46 @@ func (r *Rule) SetNextState(s string) {
 	r.nextState = s
 }
 
+// SetPushState makes the rule save the current state on the state stack, and transition to s.
+func (r *Rule) SetPushState(s string) {
+	r.pushState = s
+}
+
+// SetPopState makes the rule transition back to the state saved by the last push_state.
+func (r *Rule) SetPopState(v bool) {
+	r.popState = v
+}
+
+// SetStateTimeout makes the state that the rule transitions into time out after the given
+// number of lines in which no rule for the state matched. Rules without states don't count.
+// The state reverts to fallback, or if it's nil, to the state saved by push_state.
+func (r *Rule) SetStateTimeout(lines int, fallback *string) error {
+	if lines <= 0 {
+		return fmt.Errorf("invalid state_timeout_lines %d; must be 1 or greater", lines)
+	}
+	if r.nextState == "" && r.pushState == "" {
+		return errors.New("state_timeout_lines requires next_state or push_state")
+	}
+	r.stateTimeout = &stateTimeout{lines: lines, fallback: fallback}
+	return nil
+}
+
+// SetStateTimeoutLineString sets the decorative line written when the state times out.
+// Call it after SetStateTimeout.
+func (r *Rule) SetStateTimeoutLineString(marker, colorsStr string) error {
+	if r.stateTimeout == nil {
+		return errors.New("state_timeout_line requires state_timeout_lines")
+	}
+	c, err := r.highlighter.aliases.FromString(colorsStr)
+	if err != nil {
+		return err
+	}
+	r.stateTimeout.line = newDecorativeLine(r.highlighter, marker, c)
+	return nil
+}
+
 func (r *Rule) SetMatchColorsString(colorsStr string) error {
-	c, err := colors.FromString(colorsStr)
+	c, err := r.highlighter.aliases.FromString(colorsStr)
 	if err != nil {
 		return err
 	}
@@ -118,7 +248,7 @@ func (r *Rule) SetMatchColorsString(colorsStr string) error {
 }
 
 func (r *Rule) SetLineColorsString(colorsStr string) error {
-	c, err := colors.FromString(colorsStr)
+	c, err := r.highlighter.aliases.FromString(colorsStr)
 	if err != nil {
 		return err
 	}
@@ -127,7 +257,7 @@ func (r *Rule) SetLineColorsString(colorsStr string) error {
 }
 
 func (r *Rule) SetPreLineString(marker, colorsStr string) error {
-	c, err := colors.FromString(colorsStr)
+	c, err := r.highlighter.aliases.FromString(colorsStr)
 	if err != nil {
 		return err
 	}
@@ -136,7 +266,7 @@ func (r *Rule) SetPreLineString(marker, colorsStr string) error {
 }
 
 func (r *Rule) SetPostLineString(marker, colorsStr string) error {
-	c, err := colors.FromString(colorsStr)
+	c, err := r.highlighter.aliases.FromString(colorsStr)
 	if err != nil {
 		return err
 	}
@@ -144,6 +274,85 @@ func (r *Rule) SetPostLineString(marker, colorsStr string) error {
 	return nil
 }
 
+// SetLinkString sets a hyperlink URL template for the matches, which can refer to the
+// groups of the pattern. Call it after setting the matcher.
+func (r *Rule) SetLinkString(template string) error {
+	link, err := parseLinkTemplate(template, r.matcher.SubexpNames(), "link")
+	if err != nil {
+		return err
+	}
+	r.link = link
+	return nil
+}
+
+// AddVarString makes the rule set a state variable when it matches, which patterns can refer
+// to with ${name}. value can refer to the groups of the first match, the same way as
+// SetLinkString(). Call it after setting the matcher.
+func (r *Rule) AddVarString(name, value string) error {
+	if !varNameRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name '%s'", name)
+	}
+	t, err := parseLinkTemplate(value, r.matcher.SubexpNames(), "set."+name)
+	if err != nil {
+		return err
+	}
+	r.vars = append(r.vars, varAssignment{name: name, value: t})
+	return nil
+}
+
+// defaultGroup returns the group that "min" and "max" apply to, which is the first group,
+// or the entire match if the pattern has no groups.
+func (r *Rule) defaultGroup() int {
+	if len(r.matcher.SubexpNames()) > 1 {
+		return 1
+	}
+	return 0
+}
+
+// AddConditionString adds a numeric condition that matches must meet, such as "$1 > 250ms".
+// Call it after setting the matcher.
+func (r *Rule) AddConditionString(cond string) error {
+	c, err := parseCondition(cond, r.matcher.SubexpNames())
+	if err != nil {
+		return err
+	}
+	r.conditions = append(r.conditions, c)
+	return nil
+}
+
+// AddMinString adds a condition that the first group of matches, or the entire match if the
+// pattern has no groups, must be a number greater than or equal to min, such as "250ms".
+// Call it after setting the matcher.
+func (r *Rule) AddMinString(min string) error {
+	c, err := newCondition(r.defaultGroup(), ">=", min)
+	if err != nil {
+		return fmt.Errorf("%s in min", err)
+	}
+	r.conditions = append(r.conditions, c)
+	return nil
+}
+
+// AddMaxString is the same as AddMinString, except it adds a maximum.
+func (r *Rule) AddMaxString(max string) error {
+	c, err := newCondition(r.defaultGroup(), "<=", max)
+	if err != nil {
+		return fmt.Errorf("%s in max", err)
+	}
+	r.conditions = append(r.conditions, c)
+	return nil
+}
+
+// meetsConditions returns whether a match meets all the conditions. indexes is an element of
+// the result of Matcher.FindAllSubmatchIndex().
+func (r *Rule) meetsConditions(target []byte, indexes []int) bool {
+	for _, c := range r.conditions {
+		if !c.eval(target, indexes) {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *Rule) MustSetMatcherString(pattern string) {
 	util.Must(func() error { return r.SetMatcherString(pattern) })
 }
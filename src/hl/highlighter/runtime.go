@@ -2,11 +2,15 @@ package highlighter
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/omakoto/go-common/src/textio"
+	"github.com/omakoto/hl2/src/hl/matcher"
 	"github.com/omakoto/hl2/src/hl/term"
 	"github.com/omakoto/hl2/src/hl/util"
 	"github.com/pborman/getopt/v2"
 	"io"
+	"os"
+	"strconv"
 )
 
 var (
@@ -16,6 +20,14 @@ var (
 	hiddenMarker = []byte("---\n")
 )
 
+// ruleIds has the prefilter IDs of the matchers of a rule.
+type ruleIds struct {
+	matcher int
+	when    []int
+	whenAny []int
+	unless  []int
+}
+
 type matchResult struct {
 	rule      *Rule
 	positions [][]int
@@ -23,10 +35,20 @@ type matchResult struct {
 
 type colorsCache struct {
 	cache []*term.RenderedColors
+
+	// contrast adjusts foreground colors when --min-contrast is given; nil otherwise.
+	contrast *term.ContrastAdjuster
+
+	// links has the hyperlink URL for each byte; nil if the terminal doesn't support hyperlinks.
+	links []string
 }
 
-func newColorsCache() colorsCache {
-	return colorsCache{cache: make([]*term.RenderedColors, 4096)}
+func newColorsCache(contrast *term.ContrastAdjuster, withLinks bool) colorsCache {
+	c := colorsCache{cache: make([]*term.RenderedColors, 4096), contrast: contrast}
+	if withLinks {
+		c.links = make([]string, len(c.cache))
+	}
+	return c
 }
 
 func (c *colorsCache) prepare(lineByteCount int) {
@@ -36,11 +58,40 @@ func (c *colorsCache) prepare(lineByteCount int) {
 			size *= 2
 		}
 		c.cache = make([]*term.RenderedColors, size)
+		if c.links != nil {
+			c.links = make([]string, size)
+		}
 	}
 
 	for i := 0; i < lineByteCount; i++ {
 		c.cache[i] = nil
 	}
+	if c.links != nil {
+		for i := 0; i < lineByteCount; i++ {
+			c.links[i] = ""
+		}
+	}
+}
+
+// applyLink sets a hyperlink, unless the bytes already have one.
+func (c *colorsCache) applyLink(start, end int, url string) {
+	for i := start; i < end; i++ {
+		if c.links[i] == "" {
+			c.links[i] = url
+		}
+	}
+}
+
+func (c *colorsCache) getLink(index int) string {
+	if c.links != nil {
+		return c.links[index]
+	}
+	return ""
+}
+
+// applyBase sets the input colors, which all the other colors are applied on top of.
+func (c *colorsCache) applyBase(base []*term.RenderedColors) {
+	copy(c.cache, base)
 }
 
 func (c *colorsCache) applyColors(start, end int, colors *term.RenderedColors) {
@@ -64,6 +115,9 @@ func (c *colorsCache) applyColorsMulti(startEnds [][]int, colors *term.RenderedC
 
 func (c *colorsCache) getFg(index int) []byte {
 	if c.cache[index] != nil {
+		if c.contrast != nil {
+			return c.contrast.FgCode(c.cache[index])
+		}
 		return c.cache[index].FgCode()
 	}
 	return nil
@@ -76,6 +130,13 @@ func (c *colorsCache) getBg(index int) []byte {
 	return nil
 }
 
+func (c *colorsCache) getUl(index int) []byte {
+	if c.cache[index] != nil {
+		return c.cache[index].UlCode()
+	}
+	return nil
+}
+
 // Runtime defines a Highlighter execution highlighter.
 // Multiple Runtime's can be created for the same Highlighter instance.
 type Runtime struct {
@@ -87,15 +148,69 @@ type Runtime struct {
 	matchesCache []matchResult
 	writeCache   bytes.Buffer
 
+	// target is the current line, shared by all the matchers.
+	target matcher.Target
+
+	// fieldTarget is a field of the current line, for rules with "field", and fieldBounds
+	// caches the fields of the current line for each fieldSplitter.
+	fieldTarget matcher.Target
+	fieldBounds map[*fieldSplitter]*fieldBounds
+
+	// firstMatch has the submatch indexes of the first match of the last rule run with
+	// matchesWithConditions(), for setting the state variables.
+	firstMatch []int
+
+	// prefilter skips the matchers whose required literals aren't in the line.
+	prefilter       *matcher.Prefilter
+	prefilterResult matcher.PrefilterResult
+	ruleIds         []ruleIds
+
 	maxBefore      int
 	remainingAfter int
 	numHiddenLines int
 
+	// lineNo is the number of the current line, starting from 1.
+	lineNo int
+
+	// disabledRules has the rules disabled after their matches timed out.
+	disabledRules map[*Rule]bool
+
 	hiddenMarkWritten bool
 
 	beforeBuffer *util.BytesRingBuffer
 
+	// ansi parses escape sequences in the input; nil if InputColorsRaw.
+	ansi *ansiParser
+
+	// sanitizer makes control characters in the input visible; nil if sanitizing is disabled.
+	sanitizer *sanitizer
+
 	state string
+
+	// stateStack has the states saved by push_state; the current state is not on it.
+	stateStack []savedState
+
+	// stateTimeout is the timeout of the current state, if any, and idleLines is the number of
+	// lines since a rule for the state last matched.
+	stateTimeout *stateTimeout
+	idleLines    int
+
+	// vars has the state variables set by the rules with "set".
+	vars map[string]string
+
+	// timeoutLine is the decorative line to write before the current line, when the state
+	// timed out on it.
+	timeoutLine *decorativeLine
+}
+
+// savedState is a state saved by push_state.
+type savedState struct {
+	state   string
+	timeout *stateTimeout
+}
+
+func (s savedState) String() string {
+	return strconv.Quote(s.state)
 }
 
 // NewRuntime creates a new Runtime. Output will be written to wr.
@@ -110,12 +225,50 @@ func (h *Highlighter) NewRuntime(wr io.Writer) *Runtime {
 			r.maxBefore = rule.before
 		}
 	}
+	for _, d := range r.h.states {
+		if r.maxBefore < d.before {
+			r.maxBefore = d.before
+		}
+	}
 	r.beforeBuffer = util.NewStringRingBuffer(r.maxBefore)
-	r.colorsCache = newColorsCache()
+	r.buildPrefilter()
+	var contrast *term.ContrastAdjuster
+	if h.minContrast > 0 {
+		contrast = term.NewContrastAdjuster(h.Term(), h.minContrast)
+	}
+	r.colorsCache = newColorsCache(contrast, len(h.Term().LinkEnd()) > 0)
+	if h.inputColors != InputColorsRaw {
+		r.ansi = newAnsiParser(h.Term())
+	}
+	if h.sanitize {
+		r.sanitizer = newSanitizer(r.ansi != nil)
+	}
 
 	return &r
 }
 
+func (r *Runtime) buildPrefilter() {
+	r.prefilter = matcher.NewPrefilter()
+	r.ruleIds = make([]ruleIds, len(r.h.rules))
+
+	addAll := func(matchers []matcher.Matcher) []int {
+		ids := make([]int, len(matchers))
+		for i, m := range matchers {
+			ids[i] = r.prefilter.Add(m)
+		}
+		return ids
+	}
+	for i, rule := range r.h.rules {
+		r.ruleIds[i] = ruleIds{
+			matcher: r.prefilter.Add(rule.matcher),
+			when:    addAll(rule.when),
+			whenAny: addAll(rule.whenAny),
+			unless:  addAll(rule.unless),
+		}
+	}
+	r.prefilter.Build()
+}
+
 // Finish finalizes the output.
 func (r *Runtime) Finish() error {
 	if r.numHiddenLines > 0 {
@@ -228,14 +381,44 @@ func (r *Runtime) ColorBytes(b []byte) error {
 		lineTerminator = b[lastIndex : lastIndex+1]
 		b = b[0:lastIndex]
 	}
+	if r.sanitizer != nil {
+		b = r.sanitizer.sanitize(b)
+	}
+	if r.ansi != nil {
+		r.ansi.parse(b)
+		b = r.ansi.plain
+	}
 	b = bytes.TrimRight(b, "\r\n \t")
 	numBytes := len(b)
 
 	r.colorsCache.prepare(numBytes)
 	r.clearMatchesCache()
 
+	if r.h.inputColors == InputColorsKeep {
+		r.colorsCache.applyBase(r.ansi.base[0:numBytes])
+	}
+
 	// Find the matches.
-	matches, show, after, before := r.findMatches(b, !r.h.defaultHide)
+	r.lineNo++
+	r.target.Reset(b)
+	r.prefilter.Scan(&r.target, &r.prefilterResult)
+	r.checkStateTimeout()
+	matches, show, showSet, after, before := r.findMatches(&r.target, r.defaultShow())
+
+	// The state defaults are for the state after the transitions, so they apply to the line
+	// that enters a state, but not to the line that leaves it.
+	stateDefaults := r.h.states[r.state]
+	if !showSet {
+		show = r.defaultShow()
+	}
+	if show && stateDefaults != nil {
+		if stateDefaults.after > 0 && after < stateDefaults.after+1 {
+			after = stateDefaults.after + 1 // +1 because the current line consumes 1.
+		}
+		if before < stateDefaults.before {
+			before = stateDefaults.before
+		}
+	}
 	if show {
 		r.remainingAfter = after
 	}
@@ -252,6 +435,9 @@ func (r *Runtime) ColorBytes(b []byte) error {
 	// Pre-line
 
 	if show {
+		if r.timeoutLine != nil {
+			r.writeDecorativeLine(r.timeoutLine)
+		}
 		for i := 0; i < numMatches; i++ {
 			rule := matches[i].rule
 			if rule.preLine != nil {
@@ -264,7 +450,10 @@ func (r *Runtime) ColorBytes(b []byte) error {
 	w := r.writeCache
 	w.Truncate(0)
 
-	// First, apply the line colors.
+	// First, apply the line colors, starting with the one for the state.
+	if stateDefaults != nil && stateDefaults.lineColors != nil {
+		r.colorsCache.applyColors(0, numBytes, stateDefaults.lineColors)
+	}
 	for i := numMatches - 1; i >= 0; i-- {
 		rule := matches[i].rule
 		if rule.lineColors != nil {
@@ -279,25 +468,69 @@ func (r *Runtime) ColorBytes(b []byte) error {
 		}
 	}
 
+	// And the hyperlinks.
+	if r.colorsCache.links != nil {
+		for i := 0; i < numMatches; i++ {
+			rule := matches[i].rule
+			if rule.link != nil {
+				target, offset, ok := r.ruleTarget(rule, &r.target)
+				if !ok {
+					continue
+				}
+				m := r.resolve(rule, rule.matcher)
+				if m == nil {
+					continue
+				}
+				for _, indexes := range m.FindAllSubmatchIndex(target) {
+					if !rule.meetsConditions(target.Bytes(), indexes) {
+						continue
+					}
+					url := rule.link.expand(target.Bytes(), indexes)
+					r.colorsCache.applyLink(offset+indexes[0], offset+indexes[1], url)
+				}
+				if err := target.TakeErr(); err != nil {
+					r.matchFailed(rule, m, err)
+				}
+			}
+		}
+	}
+
 	// Finally print the built line.
 	lastFg := emptyBytes
 	lastBg := emptyBytes
+	lastUl := emptyBytes
+	lastLink := ""
 	for i := 0; i < numBytes; i++ {
+		if link := r.colorsCache.getLink(i); link != lastLink {
+			if lastLink != "" {
+				w.Write(r.h.Term().LinkEnd())
+			}
+			if link != "" {
+				w.Write(r.h.Term().LinkStart(link))
+			}
+			lastLink = link
+		}
 		fg := r.colorsCache.getFg(i)
 		bg := r.colorsCache.getBg(i)
-		if !bytes.Equal(lastFg, fg) || !bytes.Equal(lastBg, bg) {
+		ul := r.colorsCache.getUl(i)
+		if !bytes.Equal(lastFg, fg) || !bytes.Equal(lastBg, bg) || !bytes.Equal(lastUl, ul) {
 			w.Write(r.h.Term().CsiReset())
 
 			w.Write(fg)
 			w.Write(bg)
+			w.Write(ul)
 			lastFg = fg
 			lastBg = bg
+			lastUl = ul
 		}
 		w.WriteByte(b[i])
 	}
-	if len(lastFg) > 0 || len(lastBg) > 0 {
+	if len(lastFg) > 0 || len(lastBg) > 0 || len(lastUl) > 0 {
 		w.Write(r.h.Term().CsiReset())
 	}
+	if lastLink != "" {
+		w.Write(r.h.Term().LinkEnd())
+	}
 	w.Write(lineTerminator)
 
 	if show || r.remainingAfter > 0 {
@@ -319,37 +552,56 @@ func (r *Runtime) ColorBytes(b []byte) error {
 	return nil
 }
 
-func (r *Runtime) findMatches(b []byte, defaultShow bool) (matches []matchResult, show bool, after int, before int) {
+// defaultShow returns whether to show the lines that no rule shows or hides in the current state.
+func (r *Runtime) defaultShow() bool {
+	if d := r.h.states[r.state]; d != nil && d.hide != nil {
+		return !*d.hide
+	}
+	return !r.h.defaultHide
+}
+
+func (r *Runtime) findMatches(target *matcher.Target, defaultShow bool) (matches []matchResult, show, showSet bool, after int, before int) {
 	show = defaultShow
 
+	// Whether a rule for the current state matched, or the state changed.
+	stateAlive := false
+
 	numMatches := 0
 	for i := 0; i < len(r.h.rules); i++ {
 		rule := r.h.rules[i]
 
-		if !rule.isForState(r.state) {
+		if !rule.isForState(r.state) || r.disabledRules[rule] {
 			continue
 		}
-		if rule.preMatcher != nil && rule.preMatcher.Matches(b) == nil {
+		ids := &r.ruleIds[i]
+		if !r.prefilterResult.MayMatch(ids.matcher) || !r.conditionsMet(rule, ids, target) {
 			continue
 		}
-		m := rule.matcher.Matches(b)
+		m, first := r.matchRule(rule, target)
 		if m == nil {
 			continue
 		}
 		util.Debugf("Matched=%s\n", rule.matcher)
 
 		//util.Debugf("Matched=%v [%s @ %s]\n", m, rule.MatchColors, rule.LineColors)
-		if rule.nextState != "" {
-			r.state = rule.nextState
-			util.Debugf("Next state=%s\n", r.state)
+		if len(rule.states) > 0 && !rule.rangeBody {
+			stateAlive = true
+		}
+		if len(rule.vars) > 0 {
+			r.setVars(rule, target, first)
+		}
+		if r.transition(rule) {
+			stateAlive = true
 		}
 		r.matchesCache[numMatches] = matchResult{rule: rule, positions: m}
 		numMatches++
 		if rule.hide {
 			show = false
+			showSet = true
 		}
 		if rule.show {
 			show = true
+			showSet = true
 		}
 		if rule.after > 0 && show {
 			thisAfter := rule.after + 1 // +1 because the current line consumes 1.
@@ -365,22 +617,272 @@ func (r *Runtime) findMatches(b []byte, defaultShow bool) (matches []matchResult
 			break
 		}
 	}
+	if stateAlive {
+		r.idleLines = 0
+	} else {
+		r.idleLines++
+	}
 	matches = r.matchesCache[0:numMatches]
 	return
 }
 
+// transition updates the current state with the next_state, push_state or pop_state of a rule,
+// and returns whether the state changed.
+func (r *Runtime) transition(rule *Rule) bool {
+	switch {
+	case rule.nextState != "":
+		r.setState(rule.nextState, rule.stateTimeout)
+	case rule.pushState != "":
+		r.stateStack = append(r.stateStack, savedState{r.state, r.stateTimeout})
+		if len(r.stateStack) > r.h.maxStateDepth {
+			util.Debugf("State stack overflow; dropped %s\n", r.stateStack[0])
+			r.stateStack = r.stateStack[1:]
+		}
+		r.setState(rule.pushState, rule.stateTimeout)
+	case rule.popState:
+		r.popState()
+	default:
+		return false
+	}
+	util.Debugf("Next state=%q stack=%v\n", r.state, r.stateStack)
+	return true
+}
+
+func (r *Runtime) setState(state string, timeout *stateTimeout) {
+	r.state = state
+	r.stateTimeout = timeout
+	r.idleLines = 0
+}
+
+func (r *Runtime) popState() {
+	if n := len(r.stateStack); n > 0 {
+		saved := r.stateStack[n-1]
+		r.stateStack = r.stateStack[:n-1]
+		r.setState(saved.state, saved.timeout)
+	} else {
+		r.setState("", nil) // The initial state.
+	}
+}
+
+// checkStateTimeout reverts the current state if it has timed out, before the rules are
+// evaluated for the current line.
+func (r *Runtime) checkStateTimeout() {
+	r.timeoutLine = nil
+	t := r.stateTimeout
+	if t == nil || r.idleLines < t.lines {
+		return
+	}
+	r.timeoutLine = t.line
+	if t.fallback != nil {
+		r.setState(*t.fallback, nil)
+	} else {
+		r.popState()
+	}
+	util.Debugf("State timed out; next state=%q stack=%v\n", r.state, r.stateStack)
+}
+
+// ruleTarget returns what the pattern of a rule is matched against, which is either the line
+// or one of its fields, and its offset in the line. ok is false if the line doesn't have
+// the field.
+func (r *Runtime) ruleTarget(rule *Rule, target *matcher.Target) (t *matcher.Target, offset int, ok bool) {
+	if rule.field == 0 {
+		return target, 0, true
+	}
+	if r.fieldBounds == nil {
+		r.fieldBounds = make(map[*fieldSplitter]*fieldBounds)
+	}
+	fb := r.fieldBounds[rule.fieldSplitter]
+	if fb == nil {
+		fb = &fieldBounds{}
+		r.fieldBounds[rule.fieldSplitter] = fb
+	}
+	if fb.lineNo != r.lineNo {
+		fb.lineNo = r.lineNo
+		fb.bounds = rule.fieldSplitter.split(target, fb.bounds[:0])
+		if err := target.TakeErr(); err != nil {
+			r.matchFailed(rule, rule.fieldSplitter.delimiter, err)
+		}
+	}
+	i := (rule.field - 1) * 2
+	if i >= len(fb.bounds) {
+		return nil, 0, false
+	}
+	start, end := fb.bounds[i], fb.bounds[i+1]
+	r.fieldTarget.Reset(target.Bytes()[start:end])
+	return &r.fieldTarget, start, true
+}
+
+// matchRule runs the matcher of a rule against the line, or the field of the line if the rule
+// has "field", and returns the positions in the line. If the rule sets state variables, it
+// also returns the submatch indexes of the first match in the line.
+func (r *Runtime) matchRule(rule *Rule, target *matcher.Target) (m [][]int, first []int) {
+	t, offset, ok := r.ruleTarget(rule, target)
+	if !ok {
+		return nil, nil
+	}
+	if len(rule.conditions) == 0 && len(rule.vars) == 0 {
+		m = r.matches(rule, rule.matcher, t)
+	} else {
+		m, first = r.matchesWithConditions(rule, t)
+	}
+	if offset > 0 {
+		for _, pos := range m {
+			pos[0] += offset
+			pos[1] += offset
+		}
+		for i := range first {
+			if first[i] >= 0 {
+				first[i] += offset
+			}
+		}
+	}
+	return m, first
+}
+
+// matchesWithConditions returns the same positions as Matcher.Matches(), but only for the
+// matches that meet the numeric conditions of a rule, and the submatch indexes of the first
+// of them.
+func (r *Runtime) matchesWithConditions(rule *Rule, target *matcher.Target) (res [][]int, first []int) {
+	m := r.resolve(rule, rule.matcher)
+	if m == nil {
+		return nil, nil
+	}
+	for _, indexes := range m.FindAllSubmatchIndex(target) {
+		if !rule.meetsConditions(target.Bytes(), indexes) {
+			continue
+		}
+		if first == nil {
+			// Copy it, since the positions returned share it.
+			r.firstMatch = append(r.firstMatch[:0], indexes...)
+			first = r.firstMatch
+		}
+		if len(indexes) == 2 {
+			res = append(res, indexes)
+			continue
+		}
+		for i := 2; i < len(indexes); i += 2 {
+			if indexes[i] >= 0 {
+				res = append(res, indexes[i:i+2])
+			}
+		}
+	}
+	if err := target.TakeErr(); err != nil {
+		r.matchFailed(rule, m, err)
+		return nil, nil
+	}
+	return res, first
+}
+
+// conditionsMet returns whether a line meets the when, when_any and unless conditions of a rule.
+func (r *Runtime) conditionsMet(rule *Rule, ids *ruleIds, target *matcher.Target) bool {
+	for j, m := range rule.when {
+		if !r.conditionMatches(rule, m, ids.when[j], target) {
+			return false
+		}
+	}
+	if len(rule.whenAny) > 0 {
+		found := false
+		for j, m := range rule.whenAny {
+			if r.conditionMatches(rule, m, ids.whenAny[j], target) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for j, m := range rule.unless {
+		if r.conditionMatches(rule, m, ids.unless[j], target) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionMatches returns whether a when, when_any or unless matcher of a rule matches a line,
+// using the prefilter result for the matcher's prefilter ID first.
+func (r *Runtime) conditionMatches(rule *Rule, m matcher.Matcher, id int, target *matcher.Target) bool {
+	return r.prefilterResult.MayMatch(id) && r.matches(rule, m, target) != nil
+}
+
+// matches runs one of the matchers of a rule, and reports it if the match fails.
+func (r *Runtime) matches(rule *Rule, m matcher.Matcher, target *matcher.Target) [][]int {
+	m = r.resolve(rule, m)
+	if m == nil {
+		return nil
+	}
+	res := m.Matches(target)
+	if err := target.TakeErr(); err != nil {
+		r.matchFailed(rule, m, err)
+		return nil
+	}
+	return res
+}
+
+// resolve returns the matcher to run for one of the matchers of a rule, which is compiled for
+// the current values of the state variables if the pattern refers to them. It returns nil if
+// the variables aren't set yet.
+func (r *Runtime) resolve(rule *Rule, m matcher.Matcher) matcher.Matcher {
+	vm, ok := m.(*varMatcher)
+	if !ok {
+		return m
+	}
+	resolved, err := vm.resolve(r.vars)
+	if err != nil {
+		r.matchFailed(rule, m, err)
+	}
+	return resolved
+}
+
+// setVars sets the state variables of a rule that matched, from the submatch indexes of its
+// first match in the line.
+func (r *Runtime) setVars(rule *Rule, target *matcher.Target, first []int) {
+	if first == nil {
+		return
+	}
+	if r.vars == nil {
+		r.vars = make(map[string]string)
+	}
+	for _, v := range rule.vars {
+		r.vars[v.name] = v.value.expand(target.Bytes(), first)
+		util.Debugf("Set %s=%q\n", v.name, r.vars[v.name])
+	}
+}
+
+// matchFailed reports a failed match on stderr, and disables the rule if the match timed out
+// and SetDisableTimedOutRules(true) was called.
+func (r *Runtime) matchFailed(rule *Rule, m matcher.Matcher, err error) {
+	// Flush the output first, so the message appears after the lines before it.
+	if f, ok := r.wr.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+
+	if err == matcher.ErrTimeout && r.h.disableTimedOutRules {
+		if r.disabledRules == nil {
+			r.disabledRules = make(map[*Rule]bool)
+		}
+		r.disabledRules[rule] = true
+		fmt.Fprintf(os.Stderr, "hl: line %d: pattern '%s': %s; disabled the rule\n", r.lineNo, m, err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "hl: line %d: pattern '%s': %s\n", r.lineNo, m, err)
+}
+
 func (r *Runtime) writeDecorativeLine(d *decorativeLine) {
 	w := r.writeCache
 
 	fg := d.Colors.FgCode()
 	bg := d.Colors.BgCode()
+	ul := d.Colors.UlCode()
 	w.Truncate(0)
 	w.Write(fg)
 	w.Write(bg)
+	w.Write(ul)
 	for i := r.h.Term().Width() / len(d.Marker); i > 0; i-- {
 		w.Write(d.Marker)
 	}
-	if len(fg) > 0 || len(bg) > 0 {
+	if len(fg) > 0 || len(bg) > 0 || len(ul) > 0 {
 		w.Write(r.h.Term().CsiReset())
 	}
 	w.WriteByte('\n')
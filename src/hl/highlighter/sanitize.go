@@ -0,0 +1,64 @@
+package highlighter
+
+const hexDigits = "0123456789abcdef"
+
+// sanitizer renders control characters and escape sequences other than SGR sequences in a
+// visible form (e.g. "^[" for ESC), so the input can't move the cursor, set the title, etc.
+type sanitizer struct {
+	buf []byte
+
+	// keepCsi keeps all the CSI sequences as-is, because ansiParser will remove them.
+	keepCsi bool
+}
+
+func newSanitizer(keepCsi bool) *sanitizer {
+	return &sanitizer{buf: make([]byte, 0, 4096), keepCsi: keepCsi}
+}
+
+// isC1 returns whether b[i:] starts with a UTF-8 encoded C1 control character (U+0080-U+009F).
+func isC1(b []byte, i int) bool {
+	return b[i] == 0xc2 && i+1 < len(b) && 0x80 <= b[i+1] && b[i+1] <= 0x9f
+}
+
+func needsSanitizing(b []byte) bool {
+	for i, ch := range b {
+		if (ch < 0x20 && ch != '\t') || ch == 0x7f || isC1(b, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitize returns b with control characters made visible. The result is only valid until
+// the next call.
+func (s *sanitizer) sanitize(b []byte) []byte {
+	if !needsSanitizing(b) {
+		return b
+	}
+	s.buf = s.buf[:0]
+	for i := 0; i < len(b); i++ {
+		ch := b[i]
+		switch {
+		case ch == escape && i+1 < len(b) && b[i+1] == '[':
+			end := findCsiEnd(b, i+2)
+			if end >= 0 && (s.keepCsi || isSgr(b[i+2:end], b[end])) {
+				s.buf = append(s.buf, b[i:end+1]...)
+				i = end
+				continue
+			}
+			s.buf = append(s.buf, '^', '[')
+		case ch == '\t':
+			s.buf = append(s.buf, ch)
+		case ch < 0x20:
+			s.buf = append(s.buf, '^', ch+0x40)
+		case ch == 0x7f:
+			s.buf = append(s.buf, '^', '?')
+		case isC1(b, i):
+			i++
+			s.buf = append(s.buf, '\\', 'u', '0', '0', hexDigits[b[i]>>4], hexDigits[b[i]&0xf])
+		default:
+			s.buf = append(s.buf, ch)
+		}
+	}
+	return s.buf
+}
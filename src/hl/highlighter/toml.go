@@ -5,34 +5,160 @@ import (
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/omakoto/hl2/src/hl/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 )
 
+// PatternList is a list of patterns in a TOML file, which can also be given as a single string.
+// Empty patterns are ignored.
+type PatternList []string
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (p *PatternList) UnmarshalTOML(v interface{}) error {
+	switch value := v.(type) {
+	case string:
+		*p = nil
+		if value != "" {
+			*p = PatternList{value}
+		}
+		return nil
+	case []interface{}:
+		*p = make(PatternList, 0, len(value))
+		for _, e := range value {
+			s, ok := e.(string)
+			if !ok {
+				return fmt.Errorf("invalid pattern %v; must be a string", e)
+			}
+			if s != "" {
+				*p = append(*p, s)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid pattern %v; must be a string or a list of strings", v)
+}
+
+// Quantity is a number in a TOML file, which can also be given as a string with a unit,
+// such as "250ms".
+type Quantity string
+
+// UnmarshalTOML implements toml.Unmarshaler.
+func (q *Quantity) UnmarshalTOML(v interface{}) error {
+	switch value := v.(type) {
+	case string:
+		*q = Quantity(value)
+		return nil
+	case int64:
+		*q = Quantity(strconv.FormatInt(value, 10))
+		return nil
+	case float64:
+		*q = Quantity(strconv.FormatFloat(value, 'g', -1, 64))
+		return nil
+	}
+	return fmt.Errorf("invalid number %v; must be a number or a string", v)
+}
+
 type FileRule struct {
-	Pattern string `toml:"pattern"`
-	When    string `toml:"when"`
+	Pattern string      `toml:"pattern"`
+	When    PatternList `toml:"when"`
+	WhenAny PatternList `toml:"when_any"`
+	Unless  PatternList `toml:"unless"`
+
+	Field     int    `toml:"field"`
+	Delimiter string `toml:"delimiter"`
+
+	Condition string   `toml:"condition"`
+	Min       Quantity `toml:"min"`
+	Max       Quantity `toml:"max"`
 
-	Colors     string `toml:"color"`
-	LineColors string `toml:"line_color"`
+	Colors     ColorSpec `toml:"color"`
+	LineColors ColorSpec `toml:"line_color"`
 
-	PreLine        string `toml:"pre_line"`
-	PreLineColors  string `toml:"pre_line_color"`
-	PostLine       string `toml:"post_line"`
-	PostLineColors string `toml:"post_line_color"`
+	PreLine        string    `toml:"pre_line"`
+	PreLineColors  ColorSpec `toml:"pre_line_color"`
+	PostLine       string    `toml:"post_line"`
+	PostLineColors ColorSpec `toml:"post_line_color"`
+
+	Link string `toml:"link"`
+
+	Set map[string]string `toml:"set"`
 
 	Show bool `toml:"show"`
 	Hide bool `toml:"hide"`
 	Stop bool `toml:"stop"`
 
 	NextState string   `toml:"next_state"`
+	PushState string   `toml:"push_state"`
+	PopState  bool     `toml:"pop_state"`
 	States    []string `toml:"states"`
 
+	StateTimeoutLines     int       `toml:"state_timeout_lines"`
+	StateTimeoutState     *string   `toml:"state_timeout_state"`
+	StateTimeoutLine      string    `toml:"state_timeout_line"`
+	StateTimeoutLineColor ColorSpec `toml:"state_timeout_line_color"`
+
 	After  int `toml:"after"`
 	Before int `toml:"before"`
 }
 
+// FileState has the defaults for the lines in a state, given with [state.NAME].
+type FileState struct {
+	Hide       *bool     `toml:"hide"`
+	Before     int       `toml:"before"`
+	After      int       `toml:"after"`
+	LineColors ColorSpec `toml:"line_color"`
+}
+
 type RuleFile struct {
-	Rules  []FileRule `toml:"rule"`
-	Ignore string     `toml:"IGNORE"` // absorbed from self-executing TOML script headers
+	Colors map[string]ColorSpec `toml:"colors"`
+	States map[string]FileState `toml:"state"`
+	Rules  []FileRule           `toml:"rule"`
+	Ignore string               `toml:"IGNORE"` // absorbed from self-executing TOML script headers
+}
+
+type ThemeFile struct {
+	Colors map[string]ColorSpec `toml:"colors"`
+	Ignore string               `toml:"IGNORE"`
+}
+
+func decodeTomlFile(filename string, v interface{}) error {
+	md, err := toml.DecodeFile(filename, v)
+	if err != nil {
+		return err
+	}
+	if keys := undecodedKeys(&md); len(keys) > 0 {
+		return fmt.Errorf("unknown field(s) in %s: %v", filename, keys)
+	}
+	return nil
+}
+
+// colorSpecKeys are the keys whose values are ColorSpec's.
+var colorSpecKeys = map[string]bool{
+	"color":                    true,
+	"line_color":               true,
+	"pre_line_color":           true,
+	"post_line_color":          true,
+	"state_timeout_line_color": true,
+}
+
+// undecodedKeys returns the keys that weren't decoded, except for the "dark" / "light"
+// variants in color tables, which are consumed by ColorSpec.
+func undecodedKeys(md *toml.MetaData) []toml.Key {
+	ret := make([]toml.Key, 0)
+	for _, k := range md.Undecoded() {
+		if len(k) > 1 {
+			last := k[len(k)-1]
+			parent := k[:len(k)-1]
+			isColorSpec := colorSpecKeys[parent[len(parent)-1]] || (len(parent) == 2 && parent[0] == "colors")
+			if (last == "dark" || last == "light") && isColorSpec {
+				continue
+			}
+		}
+		ret = append(ret, k)
+	}
+	return ret
 }
 
 func (h *Highlighter) parseTomlFile(filename string) error {
@@ -40,16 +166,22 @@ func (h *Highlighter) parseTomlFile(filename string) error {
 	var r RuleFile
 	util.Debugf("Reading rules from '%s'...\n", filename)
 
-	md, err := toml.DecodeFile(filename, &r)
+	err := decodeTomlFile(filename, &r)
 	if err != nil {
 		return err
 	}
-	if keys := md.Undecoded(); len(keys) > 0 {
-		return fmt.Errorf("unknown field(s) in %s: %v", filename, keys)
-	}
 
 	util.Dump("Rules=", r)
 
+	h.addFileAliases(r.Colors)
+
+	for name, fs := range r.States {
+		err := h.SetStateDefaults(name, fs.Hide, fs.Before, fs.After, h.resolveColorSpec(fs.LineColors))
+		if err != nil {
+			return err
+		}
+	}
+
 	for _, fr := range r.Rules {
 		err := h.addSingleRule(&fr)
 		if err != nil {
@@ -59,6 +191,26 @@ func (h *Highlighter) parseTomlFile(filename string) error {
 	return nil
 }
 
+// addFileAliases adds the color aliases in a rule file. They're defaults; the ones from
+// a theme take precedence.
+func (h *Highlighter) addFileAliases(aliases map[string]ColorSpec) {
+	for name, spec := range aliases {
+		if !h.aliases.Has(name) {
+			h.aliases.Set(name, h.resolveColorSpec(spec))
+		}
+	}
+}
+
+func (h *Highlighter) parseTomlColors(filename string) error {
+	var r RuleFile
+	err := decodeTomlFile(filename, &r)
+	if err != nil {
+		return err
+	}
+	h.addFileAliases(r.Colors)
+	return nil
+}
+
 func (h *Highlighter) addSingleRule(fr *FileRule) error {
 	or := h.NewRule()
 
@@ -72,40 +224,124 @@ func (h *Highlighter) addSingleRule(fr *FileRule) error {
 		return err
 	}
 
-	// Prematcher
-	if fr.When != "" {
-		err := or.SetPreMatcherString(fr.When)
+	// Conditions
+	for _, p := range fr.When {
+		err := or.AddWhenString(p)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range fr.WhenAny {
+		err := or.AddWhenAnyString(p)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range fr.Unless {
+		err := or.AddUnlessString(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Field
+	err = or.SetField(fr.Field, fr.Delimiter)
+	if err != nil {
+		return err
+	}
+
+	// Numeric conditions
+	if fr.Condition != "" {
+		err = or.AddConditionString(fr.Condition)
+		if err != nil {
+			return err
+		}
+	}
+	if fr.Min != "" {
+		err = or.AddMinString(string(fr.Min))
+		if err != nil {
+			return err
+		}
+	}
+	if fr.Max != "" {
+		err = or.AddMaxString(string(fr.Max))
 		if err != nil {
 			return err
 		}
 	}
 
 	// States
+	numTransitions := 0
+	for _, t := range []bool{fr.NextState != "", fr.PushState != "", fr.PopState} {
+		if t {
+			numTransitions++
+		}
+	}
+	if numTransitions > 1 {
+		return errors.New("only one of next_state, push_state and pop_state can be used")
+	}
 	or.SetNextState(fr.NextState)
+	or.SetPushState(fr.PushState)
+	or.SetPopState(fr.PopState)
 	or.SetStates(fr.States)
+	if fr.StateTimeoutLines != 0 {
+		err = or.SetStateTimeout(fr.StateTimeoutLines, fr.StateTimeoutState)
+		if err != nil {
+			return err
+		}
+	} else if fr.StateTimeoutState != nil {
+		return errors.New("state_timeout_state requires state_timeout_lines")
+	}
+	if fr.StateTimeoutLine != "" {
+		err = or.SetStateTimeoutLineString(fr.StateTimeoutLine, h.resolveColorSpec(fr.StateTimeoutLineColor))
+		if err != nil {
+			return err
+		}
+	}
 
 	// Colors
-	err = or.SetMatchColorsString(fr.Colors)
+	err = or.SetMatchColorsString(h.resolveColorSpec(fr.Colors))
 	if err != nil {
 		return err
 	}
 
 	// Line colors
-	err = or.SetLineColorsString(fr.LineColors)
+	err = or.SetLineColorsString(h.resolveColorSpec(fr.LineColors))
 	if err != nil {
 		return err
 	}
 
 	// Pre/post lines
 	if fr.PreLine != "" {
-		err = or.SetPreLineString(fr.PreLine, fr.PreLineColors)
+		err = or.SetPreLineString(fr.PreLine, h.resolveColorSpec(fr.PreLineColors))
 		if err != nil {
 			return err
 		}
 	}
 
 	if fr.PostLine != "" {
-		err = or.SetPostLineString(fr.PostLine, fr.PostLineColors)
+		err = or.SetPostLineString(fr.PostLine, h.resolveColorSpec(fr.PostLineColors))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Hyperlink
+	if fr.Link != "" {
+		err = or.SetLinkString(fr.Link)
+		if err != nil {
+			return err
+		}
+	}
+
+	// State variables
+	names := make([]string, 0, len(fr.Set))
+	for name := range fr.Set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = or.AddVarString(name, fr.Set[name])
 		if err != nil {
 			return err
 		}
@@ -127,3 +363,38 @@ func (h *Highlighter) addSingleRule(fr *FileRule) error {
 	}
 	return nil
 }
+
+// findThemeFile returns the path to a theme file. A theme can be given either as a path,
+// or as a name of a file in $XDG_CONFIG_HOME/hl/themes/ without the ".toml" extension.
+func findThemeFile(theme string) string {
+	if _, err := os.Stat(theme); err == nil {
+		return theme
+	}
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		configDir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	path := filepath.Join(configDir, "hl", "themes", theme+".toml")
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return theme
+}
+
+func (h *Highlighter) parseThemeFile(theme string) error {
+	var t ThemeFile
+	filename := findThemeFile(theme)
+	util.Debugf("Reading theme from '%s'...\n", filename)
+
+	err := decodeTomlFile(filename, &t)
+	if err != nil {
+		return err
+	}
+
+	util.Dump("Theme=", t)
+
+	for name, spec := range t.Colors {
+		h.aliases.Set(name, h.resolveColorSpec(spec))
+	}
+	return nil
+}
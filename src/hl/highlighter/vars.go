@@ -0,0 +1,146 @@
+package highlighter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/omakoto/hl2/src/hl/matcher"
+	"github.com/omakoto/hl2/src/hl/util"
+)
+
+// varRefRe matches a reference to a state variable in a pattern, such as "${req}", with the
+// backslashes before it. An odd number of them means the "$" is escaped, as in "\${req}".
+var varRefRe = regexp.MustCompile(`(\\*)\$\{([A-Za-z_]\w*)\}`)
+
+var varNameRe = regexp.MustCompile(`^[A-Za-z_]\w*$`)
+
+// maxCompiledVarPatterns is the maximum number of compiled patterns cached for each
+// varMatcher. The cache is cleared when it's full.
+const maxCompiledVarPatterns = 64
+
+// varMatcher is the matcher for a pattern that refers to state variables with ${name}.
+// The Runtime compiles the pattern for the current values of the variables with resolve().
+// The embedded Matcher is the pattern compiled with empty values, which gives the group names.
+type varMatcher struct {
+	matcher.Matcher
+
+	pattern string
+	flags   matcher.Flags
+	vars    []string
+
+	// compiled has the compiled patterns, keyed by the values of the variables.
+	compiled map[string]matcher.Matcher
+}
+
+// findVarRefs returns the names of the variables that a pattern refers to.
+func findVarRefs(pattern string) []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, ref := range varRefRe.FindAllStringSubmatch(pattern, -1) {
+		if len(ref[1])%2 == 0 && !seen[ref[2]] {
+			seen[ref[2]] = true
+			ret = append(ret, ref[2])
+		}
+	}
+	return ret
+}
+
+// compilePattern compiles a pattern, which may refer to state variables. Fixed strings are
+// literal, so they can't.
+func compilePattern(pattern string, flags matcher.Flags) (matcher.Matcher, error) {
+	if matcher.IsFixedString(pattern, flags) {
+		return matcher.Compile(pattern, flags)
+	}
+	vars := findVarRefs(pattern)
+	if vars == nil {
+		return matcher.Compile(pattern, flags)
+	}
+	m := &varMatcher{pattern: pattern, flags: flags, vars: vars}
+	placeholder, err := matcher.Compile(m.expand(nil), flags)
+	if err != nil {
+		return nil, err
+	}
+	m.Matcher = placeholder
+	return m, nil
+}
+
+func (m *varMatcher) String() string {
+	return m.pattern
+}
+
+// RequiredLiterals returns nil, since the literals depend on the values of the variables.
+func (m *varMatcher) RequiredLiterals() []string {
+	return nil
+}
+
+// expand replaces the variables in the pattern with their values, quoted.
+func (m *varMatcher) expand(values map[string]string) string {
+	return varRefRe.ReplaceAllStringFunc(m.pattern, func(ref string) string {
+		sub := varRefRe.FindStringSubmatch(ref)
+		if len(sub[1])%2 != 0 {
+			return ref
+		}
+		return sub[1] + matcher.QuoteMeta(m.pattern, m.flags, values[sub[2]])
+	})
+}
+
+// resolve returns the matcher for the values of the variables, or nil if any of them isn't set.
+func (m *varMatcher) resolve(values map[string]string) (matcher.Matcher, error) {
+	var key strings.Builder
+	for _, name := range m.vars {
+		v, ok := values[name]
+		if !ok {
+			return nil, nil
+		}
+		key.WriteString(v)
+		key.WriteByte(0)
+	}
+	if c, ok := m.compiled[key.String()]; ok {
+		return c, nil
+	}
+	pattern := m.expand(values)
+	util.Debugf("Compiling '%s' for '%s'\n", pattern, m.pattern)
+	c, err := matcher.Compile(pattern, m.flags)
+	if m.compiled == nil || len(m.compiled) >= maxCompiledVarPatterns {
+		m.compiled = make(map[string]matcher.Matcher)
+	}
+	// Failed ones are cached too, so the error is reported only once.
+	m.compiled[key.String()] = c
+	return c, err
+}
+
+// varAssignment is a state variable set by a rule, such as "req = '$1'".
+type varAssignment struct {
+	name  string
+	value *linkTemplate
+}
+
+// CheckVars returns an error if a pattern refers to a variable that no rule sets.
+// Call it after adding all the rules.
+func (h *Highlighter) CheckVars() error {
+	defined := make(map[string]bool)
+	for _, rule := range h.rules {
+		for _, v := range rule.vars {
+			defined[v.name] = true
+		}
+	}
+	for _, rule := range h.rules {
+		matchers := []matcher.Matcher{rule.matcher}
+		matchers = append(matchers, rule.when...)
+		matchers = append(matchers, rule.whenAny...)
+		matchers = append(matchers, rule.unless...)
+		for _, m := range matchers {
+			vm, ok := m.(*varMatcher)
+			if !ok {
+				continue
+			}
+			for _, name := range vm.vars {
+				if !defined[name] {
+					return fmt.Errorf("variable ${%s} in '%s' isn't set by any rule", name, vm.pattern)
+				}
+			}
+		}
+	}
+	return nil
+}
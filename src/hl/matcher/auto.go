@@ -0,0 +1,185 @@
+package matcher
+
+import (
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+
+	"github.com/omakoto/hl2/src/hl/util"
+)
+
+// matcherAuto uses Go's engine for ASCII lines and PCRE for the others, for patterns whose
+// semantics only differ between the engines on non-ASCII lines.
+type matcherAuto struct {
+	goMatcher   *matcherGo
+	pcreMatcher *matcherPcre
+}
+
+var _ = Matcher((*matcherAuto)(nil))
+
+// engineSupport says which engine can run a pattern with the same semantics as PCRE.
+type engineSupport int
+
+const (
+	goSupported engineSupport = iota
+	goSupportedForAscii
+	pcreOnly
+)
+
+var (
+	// ungreedyFlag finds the "U" flag, which Go's engine supports, but regexp2 ignores.
+	ungreedyFlag = regexp.MustCompile(`\(\?[a-zA-Z-]*U`)
+
+	// nonAsciiEscape finds escapes that may be non-ASCII characters.
+	nonAsciiEscape = regexp.MustCompile(`\\[xpP0-7]`)
+
+	// classEscape finds Perl character classes, which are ASCII-only with Go's engine.
+	classEscape = regexp.MustCompile(`(?:^|[^\\])(?:\\\\)*\\[dDsSwW]`)
+
+	// posixClass finds POSIX classes such as "[:alpha:]", which regexp2 doesn't support.
+	posixClass = regexp.MustCompile(`\[:\^?[a-z]+:\]`)
+)
+
+// goSupport returns whether Go's engine gives the same results as PCRE for a pattern that
+// both compile.
+func goSupport(g *matcherGo, p *matcherPcre) engineSupport {
+	// PCRE numbers named groups after unnamed ones.
+	if !reflect.DeepEqual(g.SubexpNames(), p.SubexpNames()) {
+		return pcreOnly
+	}
+	if ungreedyFlag.MatchString(p.realPattern) {
+		return pcreOnly
+	}
+	// Go's pattern has Unicode classes instead of \w, \d and \s, except for the ones that
+	// can't be rewritten, such as \W inside brackets.
+	if classEscape.MatchString(g.realPattern) || posixClass.MatchString(p.realPattern) {
+		return pcreOnly
+	}
+	re, err := syntax.Parse(g.realPattern, syntax.Perl)
+	if err != nil {
+		return pcreOnly
+	}
+	// Go's engine drops empty matches right after a previous match, but PCRE doesn't; e.g.
+	// "a*" on "baaac" matches at 0, 1-4 and 5 with Go's engine, and at 4 too with PCRE.
+	if canMatchEmpty(re) {
+		return pcreOnly
+	}
+	ret := goSupported
+	folds := false
+	walk(re, func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+			// \b is ASCII-only with Go's engine, but not with PCRE.
+			ret = goSupportedForAscii
+		case syntax.OpLiteral, syntax.OpCharClass:
+			if (re.Flags & syntax.FoldCase) != 0 {
+				// The engines fold non-ASCII characters differently; e.g. Go's engine matches
+				// "K" (KELVIN SIGN) with "k", but PCRE doesn't.
+				ret = goSupportedForAscii
+				folds = true
+			}
+		}
+	})
+	// Even ASCII lines may match differently when a case-insensitive pattern has non-ASCII
+	// characters; e.g. Go's engine matches "ſ" with "s", but PCRE doesn't.
+	if folds && (hasNonAscii([]byte(g.realPattern)) || nonAsciiEscape.MatchString(g.realPattern)) {
+		return pcreOnly
+	}
+	return ret
+}
+
+// canMatchEmpty returns whether a pattern may match an empty string.
+func canMatchEmpty(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune) == 0
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL, syntax.OpNoMatch:
+		return false
+	case syntax.OpCapture, syntax.OpPlus:
+		return canMatchEmpty(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min == 0 || canMatchEmpty(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if !canMatchEmpty(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if canMatchEmpty(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	// Empty matches, anchors, word boundaries, "*" and "?".
+	return true
+}
+
+func walk(re *syntax.Regexp, f func(re *syntax.Regexp)) {
+	f(re)
+	for _, sub := range re.Sub {
+		walk(sub, f)
+	}
+}
+
+// CompileAuto compiles a pattern with Go's engine if it gives the same results as PCRE,
+// otherwise with PCRE. PCRE errors are reported even if Go's engine could compile the pattern.
+// Go's engine gets Unicode classes, the same as PCRE's, as if with the '{u}' prefix.
+func CompileAuto(pattern string, flags Flags) (Matcher, error) {
+	pm, err := CompilePcre(pattern, flags)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := pm.(*matcherPcre)
+	if !ok {
+		// Fixed strings don't use a regex engine.
+		return pm, nil
+	}
+	gm, err := CompileGo(pattern, flags|UnicodeClasses)
+	if err != nil {
+		util.Debugf("Engine=pcre for '%s' (%s)\n", pattern, err)
+		return p, nil
+	}
+	g := gm.(*matcherGo)
+
+	switch goSupport(g, p) {
+	case goSupported:
+		util.Debugf("Engine=go for '%s'\n", pattern)
+		return g, nil
+	case goSupportedForAscii:
+		util.Debugf("Engine=go for ASCII lines, pcre for others for '%s'\n", pattern)
+		return &matcherAuto{goMatcher: g, pcreMatcher: p}, nil
+	}
+	util.Debugf("Engine=pcre for '%s'\n", pattern)
+	return p, nil
+}
+
+func (r *matcherAuto) String() string {
+	return r.goMatcher.String()
+}
+
+func (r *matcherAuto) matcher(t *Target) Matcher {
+	if t.IsASCII() {
+		return r.goMatcher
+	}
+	return r.pcreMatcher
+}
+
+func (r *matcherAuto) Matches(t *Target) [][]int {
+	return r.matcher(t).Matches(t)
+}
+
+func (r *matcherAuto) FindAllSubmatchIndex(t *Target) [][]int {
+	return r.matcher(t).FindAllSubmatchIndex(t)
+}
+
+func (r *matcherAuto) SubexpNames() []string {
+	return r.pcreMatcher.SubexpNames()
+}
+
+func (r *matcherAuto) RequiredLiterals() []string {
+	return r.pcreMatcher.RequiredLiterals()
+}
@@ -0,0 +1,114 @@
+package matcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileAuto_Engine(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   Flags
+		engine  string
+	}{
+		{"abc", NoFlags, "go"},
+		{"a(b)c", NoFlags, "go"},
+		{"(?<x>a)(b)", NoFlags, "pcre"},
+		{"(a)(?<x>b)", NoFlags, "go"},
+		{"a(?=b)", NoFlags, "pcre"},
+		{"(?<!a)b", NoFlags, "pcre"},
+		{"(a)\\1", NoFlags, "pcre"},
+		{"(?>a+)b", NoFlags, "pcre"},
+		{"\\bword\\b", NoFlags, "auto"},
+		{"error", IgnoreCase, "auto"},
+		{"(?i)[a-z]+", NoFlags, "auto"},
+		{"{i}ſ", NoFlags, "pcre"},
+		{"{u}\\w+", NoFlags, "go"},
+		{"\\w+\\s\\d", NoFlags, "go"},
+		{"[\\W\\d]", NoFlags, "pcre"},
+		{"\\\\w", NoFlags, "go"},
+		{"[[:alpha:]]", NoFlags, "pcre"},
+		{"(?i)\\x{17f}", NoFlags, "pcre"},
+		{"(?i)\\pL", NoFlags, "pcre"},
+		{"\\pL", NoFlags, "go"},
+		{"(?U)a+", NoFlags, "pcre"},
+		{"(?sU:a+)", NoFlags, "pcre"},
+		{"{=}a.b", NoFlags, "fixed"},
+		{"a*", NoFlags, "pcre"},
+		{"^", NoFlags, "pcre"},
+		{"(a|b?)c*", NoFlags, "pcre"},
+		{"(a|b)c*", NoFlags, "go"},
+		{"a{0,2}b", NoFlags, "go"},
+	}
+	for _, v := range tests {
+		m, err := CompileAuto(v.pattern, v.flags)
+		if err != nil {
+			t.Errorf("p='%s' -> pattern expected to compile, but it didn't: %s", v.pattern, err)
+			continue
+		}
+		var engine string
+		switch m.(type) {
+		case *matcherGo:
+			engine = "go"
+		case *matcherPcre:
+			engine = "pcre"
+		case *matcherAuto:
+			engine = "auto"
+		case *matcherFixed:
+			engine = "fixed"
+		}
+		if engine != v.engine {
+			t.Errorf("p='%s' -> engine must be %s, but was %s", v.pattern, v.engine, engine)
+		}
+	}
+}
+
+func TestCompileAuto_SameAsPcre(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   Flags
+		target  string
+	}{
+		{"\\bfoo", NoFlags, "éfoo foo"},
+		{"\\bfoo", NoFlags, "xfoo foo"},
+		{"K", IgnoreCase, "k K K"},
+		{"(?<x>a)(b)", NoFlags, "ab"},
+		{"(a)?(b)", NoFlags, "b ab"},
+		{"a+", NoFlags, "aaa"},
+		{"a*", NoFlags, "baaac"},
+		{"\\w+", NoFlags, "café x"},
+		{"\\d+", NoFlags, "12３４"},
+		{"\\s", NoFlags, "a\u3000b\vc"},
+	}
+	for _, v := range tests {
+		a, err := CompileAuto(v.pattern, v.flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := CompilePcre(v.pattern, v.flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		target := NewTarget([]byte(v.target))
+		if ra, rp := a.Matches(target), p.Matches(target); !reflect.DeepEqual(ra, rp) {
+			t.Errorf("p='%s' t='%s' -> Matches() must be %+v, but was %+v", v.pattern, v.target, rp, ra)
+		}
+		if ra, rp := a.FindAllSubmatchIndex(target), p.FindAllSubmatchIndex(target); !reflect.DeepEqual(ra, rp) {
+			t.Errorf("p='%s' t='%s' -> FindAllSubmatchIndex() must be %+v, but was %+v", v.pattern, v.target, rp, ra)
+		}
+		if ra, rp := a.SubexpNames(), p.SubexpNames(); !reflect.DeepEqual(ra, rp) {
+			t.Errorf("p='%s' -> SubexpNames() must be %q, but was %q", v.pattern, rp, ra)
+		}
+	}
+}
+
+func TestParseEngine(t *testing.T) {
+	for s, expected := range map[string]Engine{"": EngineAuto, "auto": EngineAuto, "PCRE": EnginePcre, "go": EngineGo, "re2": EngineGo} {
+		if e, err := ParseEngine(s); err != nil || e != expected {
+			t.Errorf("'%s' -> must be %s, but was %s (%v)", s, expected, e, err)
+		}
+	}
+	if _, err := ParseEngine("perl"); err == nil {
+		t.Errorf("'perl' must be an error")
+	}
+}
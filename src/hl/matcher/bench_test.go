@@ -0,0 +1,106 @@
+package matcher
+
+import (
+	"bufio"
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	sampleRules = "../../../samples/highlighter-logcat.toml"
+	sampleLog   = "../../../samples/sample.log"
+)
+
+// loadSamples loads the patterns in the sample rule file and the lines in the sample log.
+func loadSamples(b testing.TB) (patterns []string, lines [][]byte) {
+	var rules struct {
+		Rule []struct {
+			Pattern string
+			When    string
+		}
+	}
+	if _, err := toml.DecodeFile(sampleRules, &rules); err != nil {
+		b.Fatal(err)
+	}
+	for _, r := range rules.Rule {
+		patterns = append(patterns, r.Pattern)
+		if r.When != "" {
+			patterns = append(patterns, r.When)
+		}
+	}
+
+	f, err := os.Open(sampleLog)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, append([]byte(nil), s.Bytes()...))
+	}
+	return
+}
+
+func benchmarkMatchers(b *testing.B, compile func(string, Flags) (Matcher, error), usePrefilter bool) {
+	patterns, lines := loadSamples(b)
+	matchers := make([]Matcher, 0, len(patterns))
+	for _, p := range patterns {
+		m, err := compile(p, NoFlags)
+		if err != nil {
+			b.Fatalf("%s: %s", p, err)
+		}
+		matchers = append(matchers, m)
+	}
+
+	p := NewPrefilter()
+	for _, m := range matchers {
+		p.Add(m)
+	}
+	p.Build()
+	res := PrefilterResult{}
+
+	target := NewTarget(nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, line := range lines {
+			target.Reset(line)
+			if usePrefilter {
+				p.Scan(target, &res)
+			}
+			for id, m := range matchers {
+				if usePrefilter && !res.MayMatch(id) {
+					continue
+				}
+				m.Matches(target)
+			}
+		}
+	}
+}
+
+func BenchmarkMatchers_Pcre(b *testing.B) {
+	benchmarkMatchers(b, CompilePcre, false)
+}
+
+func BenchmarkMatchers_PcrePrefilter(b *testing.B) {
+	benchmarkMatchers(b, CompilePcre, true)
+}
+
+func BenchmarkMatchers_Go(b *testing.B) {
+	benchmarkMatchers(b, CompileGo, false)
+}
+
+func BenchmarkMatchers_GoPrefilter(b *testing.B) {
+	benchmarkMatchers(b, CompileGo, true)
+}
+
+func BenchmarkMatchers_Auto(b *testing.B) {
+	benchmarkMatchers(b, CompileAuto, false)
+}
+
+func BenchmarkMatchers_AutoPrefilter(b *testing.B) {
+	benchmarkMatchers(b, CompileAuto, true)
+}
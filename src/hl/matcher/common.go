@@ -3,41 +3,193 @@ package matcher
 import (
 	"bytes"
 	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-func preProcess(pattern *string, negate *bool) error {
+// prefix has the flags given with the "{...}" prefix of a pattern.
+type prefix struct {
+	negate       bool // '!'
+	removeSpaces bool // '#'
+	fixed        bool // '='
+	word         bool // 'w'
+	ignoreCase   bool // 'i', or 'I' for false
+	multiline    bool // 'm'
+	dotAll       bool // 's'
+	unicode      bool // 'u'
+}
+
+// preProcess parses the "{...}" prefix of a pattern, and returns the rest of the pattern and
+// the prefix. flags give the defaults of the prefix flags.
+func preProcess(pattern string, flags Flags) (string, prefix, error) {
+	p := prefix{
+		fixed:      (flags & FixedString) != 0,
+		word:       (flags & WholeWord) != 0,
+		ignoreCase: (flags & IgnoreCase) != 0,
+		unicode:    (flags & UnicodeClasses) != 0,
+	}
 	i := 0
-	if len(*pattern) <= i || (*pattern)[i] != '{' {
-		return nil
+	if len(pattern) <= i || pattern[i] != '{' {
+		if p.unicode && !p.fixed {
+			pattern = unicodeClasses(pattern)
+		}
+		return pattern, p, nil
 	}
-	removeSpaces := false
 LOOP:
 	for {
 		i++
-		if len(*pattern) <= i {
-			return errors.New("unterminated prefix in '" + *pattern + "'")
+		if len(pattern) <= i {
+			return "", p, errors.New("unterminated prefix in '" + pattern + "'")
 		}
-		switch (*pattern)[i] {
+		switch pattern[i] {
 		case '}':
 			break LOOP
 		case '!':
-			*negate = true
+			p.negate = true
 		case '#':
-			removeSpaces = true
+			p.removeSpaces = true
+		case '=':
+			p.fixed = true
+		case 'w':
+			p.word = true
+		case 'i':
+			p.ignoreCase = true
+		case 'I':
+			p.ignoreCase = false
+		case 'm':
+			p.multiline = true
+		case 's':
+			p.dotAll = true
+		case 'u':
+			p.unicode = true
 		default:
-			return errors.New("unknown prefix in '" + *pattern + "'")
+			return "", p, errors.New("unknown prefix in '" + pattern + "'")
 		}
 	}
 	i++
-	*pattern = (*pattern)[i:]
-	if removeSpaces {
+	realPattern := pattern[i:]
+	if p.removeSpaces {
 		var err error
-		*pattern, err = removeExtras(*pattern)
+		realPattern, err = removeExtras(realPattern)
 		if err != nil {
-			return err
+			return "", p, err
 		}
 	}
-	return nil
+	if p.unicode && !p.fixed {
+		realPattern = unicodeClasses(realPattern)
+	}
+	return realPattern, p, nil
+}
+
+// IsFixedString returns whether a pattern is a fixed string, with the '{=}' prefix or the
+// FixedString flag.
+func IsFixedString(pattern string, flags Flags) bool {
+	_, p, err := preProcess(pattern, flags)
+	return err == nil && p.fixed
+}
+
+// QuoteMeta returns a string that matches s literally when it's inserted into a pattern
+// after the "{...}" prefix, which is needed because '{=}' patterns are already literal and
+// '{#}' patterns ignore spaces.
+func QuoteMeta(pattern string, flags Flags, s string) string {
+	_, p, err := preProcess(pattern, flags)
+	if err != nil {
+		return regexp.QuoteMeta(s)
+	}
+	if !p.fixed {
+		s = regexp.QuoteMeta(s)
+	}
+	if p.removeSpaces {
+		s = strings.ReplaceAll(s, " ", `\ `)
+	}
+	return s
+}
+
+// Unicode-aware replacements for the Perl character classes, used for the '{u}' prefix.
+// They're the same as PCRE's classes. The ones inside brackets can't be negated, so \W and \S
+// are kept as-is inside brackets.
+var (
+	unicodeClassesOutside = map[byte]string{
+		'w': `[\p{L}\p{Mn}\p{Nd}\p{Pc}\x{200C}\x{200D}]`,
+		'W': `[^\p{L}\p{Mn}\p{Nd}\p{Pc}\x{200C}\x{200D}]`,
+		'd': `\p{Nd}`,
+		'D': `\P{Nd}`,
+		's': `[\t\n\v\f\r\x20\x85\p{Z}]`,
+		'S': `[^\t\n\v\f\r\x20\x85\p{Z}]`,
+	}
+	unicodeClassesInside = map[byte]string{
+		'w': `\p{L}\p{Mn}\p{Nd}\p{Pc}\x{200C}\x{200D}`,
+		'd': `\p{Nd}`,
+		'D': `\P{Nd}`,
+		's': `\t\n\v\f\r\x20\x85\p{Z}`,
+	}
+)
+
+// unicodeClasses rewrites \w, \d and \s in a pattern into Unicode-aware classes, which both
+// engines support. They're ASCII-only with Go's engine otherwise.
+func unicodeClasses(pattern string) string {
+	var buf bytes.Buffer
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case ch == '\\' && i+1 < len(pattern):
+			i++
+			next := pattern[i]
+			if next == 'Q' {
+				// \Q...\E is literal.
+				end := strings.Index(pattern[i:], `\E`)
+				if end < 0 {
+					end = len(pattern) - i
+				} else {
+					end += 2
+				}
+				buf.WriteString(pattern[i-1 : i+end])
+				i += end - 1
+				continue
+			}
+			replacements := unicodeClassesOutside
+			if inClass {
+				replacements = unicodeClassesInside
+			}
+			if r, ok := replacements[next]; ok {
+				buf.WriteString(r)
+			} else {
+				buf.WriteByte(ch)
+				buf.WriteByte(next)
+			}
+		case inClass:
+			if ch == '[' && i+1 < len(pattern) && pattern[i+1] == ':' {
+				// A POSIX class, such as [:alpha:].
+				if end := strings.Index(pattern[i:], ":]"); end >= 0 {
+					buf.WriteString(pattern[i : i+end+2])
+					i += end + 1
+					continue
+				}
+			}
+			if ch == ']' {
+				inClass = false
+			}
+			buf.WriteByte(ch)
+		case ch == '[':
+			inClass = true
+			buf.WriteByte(ch)
+			// A ']' right after '[' or '[^' is a literal.
+			if i+1 < len(pattern) && pattern[i+1] == '^' {
+				i++
+				buf.WriteByte('^')
+			}
+			if i+1 < len(pattern) && pattern[i+1] == ']' {
+				i++
+				buf.WriteByte(']')
+			}
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
 }
 
 // removeExtras removes unescaped spaces from a string.
@@ -64,3 +216,38 @@ func removeExtras(s string) (string, error) {
 	}
 	return buf.String(), nil
 }
+
+// negatedMatch returns the result of FindAllSubmatchIndex() for a negated matcher that
+// didn't match: the entire target, with no groups.
+func negatedMatch(target []byte, numSubexp int) [][]int {
+	ret := make([]int, (numSubexp+1)*2)
+	for i := range ret {
+		ret[i] = -1
+	}
+	ret[0] = 0
+	ret[1] = len(target)
+	return [][]int{ret}
+}
+
+func isWordByte(b []byte, i int) bool {
+	r, _ := utf8.DecodeRune(b[i:])
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isWordByteBefore(b []byte, i int) bool {
+	r, _ := utf8.DecodeLastRune(b[:i])
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isWordMatch returns whether b[start:end] is a whole word, which means it's neither
+// preceded nor followed by a word character (a letter, a digit or an underscore),
+// like "grep -w".
+func isWordMatch(b []byte, start, end int) bool {
+	if start > 0 && isWordByteBefore(b, start) {
+		return false
+	}
+	if end < len(b) && isWordByte(b, end) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package matcher
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// matcherFixed matches a fixed string, for the '{=}' prefix. It's used for both engines.
+type matcherFixed struct {
+	srcPattern string
+	literal    []byte
+	ignoreCase bool
+	negate     bool
+	word       bool
+}
+
+var _ = Matcher((*matcherFixed)(nil))
+
+func newMatcherFixed(srcPattern, literal string, prefix prefix) *matcherFixed {
+	return &matcherFixed{
+		srcPattern: srcPattern,
+		literal:    []byte(literal),
+		ignoreCase: prefix.ignoreCase,
+		negate:     prefix.negate,
+		word:       prefix.word,
+	}
+}
+
+func (r *matcherFixed) String() string {
+	return r.srcPattern
+}
+
+// equalFoldPrefix returns the length of the prefix of b that matches the literal
+// case-insensitively, or -1 if b doesn't start with it.
+func (r *matcherFixed) equalFoldPrefix(b []byte) int {
+	i := 0
+	for lit := r.literal; len(lit) > 0; {
+		if i >= len(b) {
+			return -1
+		}
+		lr, lsize := utf8.DecodeRune(lit)
+		br, bsize := utf8.DecodeRune(b[i:])
+		lit = lit[lsize:]
+		i += bsize
+		if lr == br {
+			continue
+		}
+		// Same as strings.EqualFold().
+		f := unicode.SimpleFold(lr)
+		for f != lr && f != br {
+			f = unicode.SimpleFold(f)
+		}
+		if f != br {
+			return -1
+		}
+	}
+	return i
+}
+
+// index returns the position and the length of the first match in b, or -1.
+func (r *matcherFixed) index(b []byte) (int, int) {
+	if !r.ignoreCase {
+		return bytes.Index(b, r.literal), len(r.literal)
+	}
+	for i := 0; i <= len(b); {
+		if n := r.equalFoldPrefix(b[i:]); n >= 0 {
+			return i, n
+		}
+		if i == len(b) {
+			break
+		}
+		_, size := utf8.DecodeRune(b[i:])
+		i += size
+	}
+	return -1, 0
+}
+
+// findAll returns all the matches, skipping the ones that aren't whole words when needed.
+func (r *matcherFixed) findAll(b []byte, max int) [][]int {
+	var res [][]int
+	for start := 0; start <= len(b); {
+		i, n := r.index(b[start:])
+		if i < 0 {
+			break
+		}
+		i += start
+		matched := !r.word || isWordMatch(b, i, i+n)
+		if matched {
+			res = append(res, []int{i, i + n})
+			if len(res) == max {
+				break
+			}
+		}
+		if matched && n > 0 {
+			start = i + n
+		} else if i < len(b) {
+			// Empty match, or not a whole word; move on to the next rune.
+			_, size := utf8.DecodeRune(b[i:])
+			start = i + size
+		} else {
+			break
+		}
+	}
+	return res
+}
+
+func (r *matcherFixed) Matches(t *Target) [][]int {
+	target := t.Bytes()
+	if r.negate {
+		if r.findAll(target, 1) == nil {
+			return [][]int{{0, len(target)}}
+		}
+		return nil
+	}
+	return r.findAll(target, -1)
+}
+
+func (r *matcherFixed) FindAllSubmatchIndex(t *Target) [][]int {
+	return r.Matches(t)
+}
+
+func (r *matcherFixed) SubexpNames() []string {
+	return []string{""}
+}
+
+func (r *matcherFixed) RequiredLiterals() []string {
+	if r.negate || len(r.literal) == 0 {
+		return nil
+	}
+	if r.ignoreCase && !isFoldableLiteral([]rune(string(r.literal))) {
+		return nil
+	}
+	return []string{string(r.literal)}
+}
@@ -0,0 +1,102 @@
+package matcher
+
+import (
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxLiterals is the maximum number of literals extracted from a pattern. Patterns that'd
+// need more (e.g. big alternations) don't use the prefilter.
+const maxLiterals = 16
+
+// extractLiterals returns strings at least one of which every match of a pattern contains,
+// or nil if there's no such set. The pattern is parsed with Go's syntax, so use it for PCRE
+// patterns only when Go's engine gives the same results. See pcreLiterals().
+func extractLiterals(pattern string, flags Flags) []string {
+	parseFlags := syntax.Perl
+	if (flags & IgnoreCase) != 0 {
+		parseFlags |= syntax.FoldCase
+	}
+	re, err := syntax.Parse(pattern, parseFlags)
+	if err != nil {
+		return nil
+	}
+	return requiredLiterals(re.Simplify())
+}
+
+// isFoldableLiteral returns whether a case-insensitive literal can be found by the prefilter,
+// which only folds ASCII letters and the runes that fold into them.
+func isFoldableLiteral(runes []rune) bool {
+	for _, r := range runes {
+		if r >= utf8.RuneSelf && unicode.SimpleFold(r) != r {
+			if _, ok := foldToAscii(r); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// literalsScore returns how good a literal set is as a filter; longer and fewer literals are better.
+func literalsScore(literals []string) int {
+	if literals == nil {
+		return -1
+	}
+	shortest := len(literals[0])
+	for _, l := range literals[1:] {
+		if len(l) < shortest {
+			shortest = len(l)
+		}
+	}
+	return shortest*maxLiterals - len(literals)
+}
+
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if len(re.Rune) == 0 {
+			return nil
+		}
+		if (re.Flags&syntax.FoldCase) != 0 && !isFoldableLiteral(re.Rune) {
+			return nil
+		}
+		return []string{string(re.Rune)}
+
+	case syntax.OpCapture:
+		return requiredLiterals(re.Sub[0])
+
+	case syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+		return nil
+
+	case syntax.OpConcat:
+		var best []string
+		for _, sub := range re.Sub {
+			if l := requiredLiterals(sub); literalsScore(l) > literalsScore(best) {
+				best = l
+			}
+		}
+		return best
+
+	case syntax.OpAlternate:
+		var ret []string
+		for _, sub := range re.Sub {
+			l := requiredLiterals(sub)
+			if l == nil {
+				return nil
+			}
+			ret = append(ret, l...)
+			if len(ret) > maxLiterals {
+				return nil
+			}
+		}
+		return ret
+	}
+	return nil
+}
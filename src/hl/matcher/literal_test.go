@@ -0,0 +1,99 @@
+package matcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLiterals(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		flags    Flags
+		literals []string
+	}{
+		{"abc", NoFlags, []string{"abc"}},
+		{"", NoFlags, nil},
+		{".*", NoFlags, nil},
+		{"\\bActivityManager: START", NoFlags, []string{"ActivityManager: START"}},
+		{"ab\\d+cdef", NoFlags, []string{"cdef"}},
+		{"(ERROR|WARN)", NoFlags, []string{"ERROR", "WARN"}},
+		{"(?:ERROR|WARN):", NoFlags, []string{"ERROR", "WARN"}},
+		{"(ERROR|.*)", NoFlags, nil},
+		{"a?b?", NoFlags, nil},
+		{"(abc)+", NoFlags, []string{"abc"}},
+		{"(abc){2,3}", NoFlags, []string{"abc"}},
+		{"(abc){0,3}", NoFlags, nil},
+		{"(?i)error", NoFlags, []string{"ERROR"}},
+		{"error", IgnoreCase, []string{"ERROR"}},
+		{"①②", NoFlags, []string{"①②"}},
+		{"(?i)①②", NoFlags, []string{"①②"}},
+
+		// Non-ASCII letters can't be found case-insensitively.
+		{"(?i)café", NoFlags, nil},
+		{"café", NoFlags, []string{"café"}},
+
+		// PCRE-only syntax.
+		{"a(?=b)", NoFlags, nil},
+		{"(a)\\1", NoFlags, nil},
+	}
+	for _, v := range tests {
+		literals := extractLiterals(v.pattern, v.flags)
+		if !reflect.DeepEqual(literals, v.literals) {
+			t.Errorf("p='%s' -> literals must be %q, but was %q", v.pattern, v.literals, literals)
+		}
+	}
+
+	// Negated patterns have no literals.
+	for _, compile := range []func(string, Flags) (Matcher, error){CompileGo, CompilePcre} {
+		m, _ := compile("{!}abc", NoFlags)
+		if m.RequiredLiterals() != nil {
+			t.Errorf("negated patterns must have no literals, but was %q", m.RequiredLiterals())
+		}
+		m, _ = compile("{#} a b c", NoFlags)
+		if !reflect.DeepEqual(m.RequiredLiterals(), []string{"abc"}) {
+			t.Errorf("literals must be [abc], but was %q", m.RequiredLiterals())
+		}
+		m, _ = compile("{=}a.c", NoFlags)
+		if !reflect.DeepEqual(m.RequiredLiterals(), []string{"a.c"}) {
+			t.Errorf("literals must be [a.c], but was %q", m.RequiredLiterals())
+		}
+		m, _ = compile("{=}café", IgnoreCase)
+		if m.RequiredLiterals() != nil {
+			t.Errorf("non-foldable literals must have no literals, but was %q", m.RequiredLiterals())
+		}
+	}
+}
+
+func TestPcreLiterals(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		flags    Flags
+		literals []string
+	}{
+		{"abc", NoFlags, []string{"abc"}},
+		{"\\bfoo\\b", NoFlags, []string{"foo"}},
+		{"error", IgnoreCase, []string{"ERROR"}},
+		{"{w}error", NoFlags, []string{"error"}},
+		{"\\w+: start", NoFlags, []string{": start"}},
+
+		// Patterns that Go's syntax parses differently.
+		{"[[:alpha:]]x", NoFlags, nil},
+		{"(?U)ab+", NoFlags, nil},
+		{"(?<x>a)(b)c", NoFlags, nil},
+		{"[\\W]abc", NoFlags, nil},
+
+		// Patterns that Go's syntax doesn't support.
+		{"abc(?=d)", NoFlags, nil},
+		{"(abc)\\1", NoFlags, nil},
+	}
+	for _, v := range tests {
+		m, err := CompilePcre(v.pattern, v.flags)
+		if err != nil {
+			t.Errorf("p='%s' -> pattern expected to compile, but it didn't: %s", v.pattern, err)
+			continue
+		}
+		if literals := m.RequiredLiterals(); !reflect.DeepEqual(literals, v.literals) {
+			t.Errorf("p='%s' -> literals must be %q, but was %q", v.pattern, v.literals, literals)
+		}
+	}
+}
@@ -1,20 +1,99 @@
 package matcher
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
 const (
 	NoFlags    Flags = 0
 	IgnoreCase Flags = 1 << iota
+
+	// FixedString makes all the patterns fixed strings, like the '{=}' prefix.
+	FixedString
+
+	// WholeWord makes all the patterns match only whole words, like the '{w}' prefix.
+	WholeWord
+
+	// UnicodeClasses makes \w, \d and \s Unicode-aware with Go's engine too, like the '{u}' prefix.
+	UnicodeClasses
 )
 
+// Matcher matches a pattern against a Target. Use the same Target for all the matchers for
+// a line, so the conversions they need are shared.
 type Matcher interface {
-	Matches(target []byte) [][]int
+	// Matches returns the byte offsets of the matches, or the groups if the pattern has any.
+	Matches(target *Target) [][]int
+
+	// FindAllSubmatchIndex returns the byte offsets of all the matches and their groups,
+	// in the same format as regexp.Regexp.FindAllSubmatchIndex().
+	// A negated matcher returns the entire target with no groups, when it doesn't match.
+	FindAllSubmatchIndex(target *Target) [][]int
+
+	// RequiredLiterals returns strings at least one of which every match contains, or nil if
+	// there's no such set, e.g. for negated patterns. Used by Prefilter.
+	RequiredLiterals() []string
+
+	// SubexpNames returns the names of the groups, in the same format as
+	// regexp.Regexp.SubexpNames().
+	SubexpNames() []string
+
 	String() string
 }
 
-var NoPcre = false
+// Engine is a regex engine.
+type Engine int
+
+const (
+	// EngineAuto uses Go's engine for patterns that it supports with the same semantics as
+	// PCRE, and PCRE for the rest, e.g. patterns with lookarounds or backreferences.
+	EngineAuto Engine = iota
+	EnginePcre
+	EngineGo
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EnginePcre:
+		return "pcre"
+	case EngineGo:
+		return "go"
+	}
+	return "auto"
+}
+
+// ParseEngine parses an --engine value.
+func ParseEngine(s string) (Engine, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return EngineAuto, nil
+	case "pcre":
+		return EnginePcre, nil
+	case "go", "re2":
+		return EngineGo, nil
+	}
+	return EngineAuto, fmt.Errorf("invalid engine '%s'; must be auto, pcre or go", s)
+}
+
+// DefaultEngine is the engine used by Compile().
+var DefaultEngine = EngineAuto
+
+// MatchTimeout is the timeout of each match with PCRE patterns compiled after it's set, so a
+// pattern with catastrophic backtracking can't make a line take forever. 0 means no timeout.
+// Go's engine runs in linear time, so it doesn't need one.
+var MatchTimeout time.Duration
+
+// ErrTimeout is returned by Target.TakeErr() when a match timed out.
+var ErrTimeout = errors.New("match timed out")
 
 func Compile(pattern string, flags Flags) (Matcher, error) {
-	if NoPcre {
+	switch DefaultEngine {
+	case EngineGo:
 		return CompileGo(pattern, flags)
+	case EnginePcre:
+		return CompilePcre(pattern, flags)
 	}
-	return CompilePcre(pattern, flags)
+	return CompileAuto(pattern, flags)
 }
@@ -0,0 +1,231 @@
+package matcher
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// foldToAscii returns the lowercase ASCII letter that a non-ASCII rune matches case-insensitively,
+// such as 'k' for U+212A (KELVIN SIGN).
+func foldToAscii(r rune) (byte, bool) {
+	if l := unicode.ToLower(r); l < utf8.RuneSelf {
+		return byte(l), true
+	}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < utf8.RuneSelf {
+			return byte(unicode.ToLower(f)), true
+		}
+	}
+	return 0, false
+}
+
+func toLowerAscii(ch byte) byte {
+	if 'A' <= ch && ch <= 'Z' {
+		return ch + ('a' - 'A')
+	}
+	return ch
+}
+
+// appendFolded appends a string folded for the prefilter: ASCII letters are lowercased, and
+// non-ASCII runes that match ASCII letters case-insensitively are converted into them.
+// Folding is done rune by rune, so if a line contains a literal, the folded line contains
+// the folded literal.
+func appendFolded(buf []byte, b []byte) []byte {
+	for i := 0; i < len(b); {
+		ch := b[i]
+		if ch < utf8.RuneSelf {
+			buf = append(buf, toLowerAscii(ch))
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if f, ok := foldToAscii(r); ok && r != utf8.RuneError {
+			buf = append(buf, f)
+		} else {
+			buf = append(buf, b[i:i+size]...)
+		}
+		i += size
+	}
+	return buf
+}
+
+func hasNonAscii(b []byte) bool {
+	for _, ch := range b {
+		if ch >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// Prefilter finds the required literals of multiple matchers in a line at once, with an
+// Aho-Corasick automaton, so matchers whose literals don't appear in a line can be skipped.
+// A Prefilter can't be modified once Build() is called, but it can be shared by multiple
+// goroutines.
+type Prefilter struct {
+	numMatchers int
+
+	// alwaysMatch has the IDs of the matchers that have no literals.
+	alwaysMatch []int
+
+	literals [][]byte
+	owners   [][]int // owners[i] has the IDs of the matchers that require literals[i].
+
+	// The automaton. The input bytes are mapped into classes, so the transition table has
+	// numClasses entries per state.
+	classes    [256]byte
+	numClasses int
+	next       []int32
+	outputs    [][]int // outputs[state] has the indexes of the literals found at the state.
+}
+
+// NewPrefilter creates a new Prefilter.
+func NewPrefilter() *Prefilter {
+	return &Prefilter{}
+}
+
+// Add adds a matcher, and returns its ID.
+func (p *Prefilter) Add(m Matcher) int {
+	id := p.numMatchers
+	p.numMatchers++
+
+	literals := m.RequiredLiterals()
+	if len(literals) == 0 {
+		p.alwaysMatch = append(p.alwaysMatch, id)
+		return id
+	}
+	for _, l := range literals {
+		folded := appendFolded(nil, []byte(l))
+		index := -1
+		for i, existing := range p.literals {
+			if string(existing) == string(folded) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			index = len(p.literals)
+			p.literals = append(p.literals, folded)
+			p.owners = append(p.owners, nil)
+		}
+		p.owners[index] = append(p.owners[index], id)
+	}
+	return id
+}
+
+// NumMatchers returns the number of matchers added.
+func (p *Prefilter) NumMatchers() int {
+	return p.numMatchers
+}
+
+// Build builds the automaton.
+func (p *Prefilter) Build() {
+	// Byte classes; 0 is for the bytes that don't appear in any literal.
+	p.numClasses = 1
+	for _, l := range p.literals {
+		for _, ch := range l {
+			if p.classes[ch] == 0 {
+				p.classes[ch] = byte(p.numClasses)
+				p.numClasses++
+			}
+		}
+	}
+	// Uppercase ASCII letters are folded.
+	for ch := 'A'; ch <= 'Z'; ch++ {
+		p.classes[ch] = p.classes[ch+('a'-'A')]
+	}
+
+	// The trie.
+	p.next = make([]int32, p.numClasses)
+	p.outputs = [][]int{nil}
+	for i, l := range p.literals {
+		state := int32(0)
+		for _, ch := range l {
+			c := int32(p.classes[ch])
+			if p.next[state*int32(p.numClasses)+c] == 0 {
+				p.next[state*int32(p.numClasses)+c] = int32(len(p.outputs))
+				p.next = append(p.next, make([]int32, p.numClasses)...)
+				p.outputs = append(p.outputs, nil)
+			}
+			state = p.next[state*int32(p.numClasses)+c]
+		}
+		p.outputs[state] = append(p.outputs[state], i)
+	}
+
+	// Failure links, in BFS order, turning the trie into a DFA.
+	n := int32(p.numClasses)
+	fail := make([]int32, len(p.outputs))
+	queue := make([]int32, 0, len(p.outputs))
+	for c := int32(0); c < n; c++ {
+		if s := p.next[c]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		p.outputs[state] = append(p.outputs[state], p.outputs[fail[state]]...)
+		for c := int32(0); c < n; c++ {
+			s := p.next[state*n+c]
+			if s == 0 {
+				p.next[state*n+c] = p.next[fail[state]*n+c]
+				continue
+			}
+			fail[s] = p.next[fail[state]*n+c]
+			queue = append(queue, s)
+		}
+	}
+}
+
+// PrefilterResult has the result of Prefilter.Scan(). It can be reused for multiple lines.
+type PrefilterResult struct {
+	mayMatch  []bool
+	foundLits []bool
+	folded    []byte
+}
+
+// MayMatch returns whether the matcher with the ID may match the line. If it returns false,
+// the matcher definitely doesn't match.
+func (r *PrefilterResult) MayMatch(id int) bool {
+	return r.mayMatch[id]
+}
+
+// Scan finds the literals in a line, and sets the result to res.
+func (p *Prefilter) Scan(t *Target, res *PrefilterResult) {
+	if len(res.mayMatch) != p.numMatchers {
+		res.mayMatch = make([]bool, p.numMatchers)
+		res.foundLits = make([]bool, len(p.literals))
+	}
+	for i := range res.mayMatch {
+		res.mayMatch[i] = false
+	}
+	for i := range res.foundLits {
+		res.foundLits[i] = false
+	}
+	for _, id := range p.alwaysMatch {
+		res.mayMatch[id] = true
+	}
+	if len(p.literals) == 0 {
+		return
+	}
+
+	b := t.Bytes()
+	if hasNonAscii(b) {
+		res.folded = appendFolded(res.folded[:0], b)
+		b = res.folded
+	}
+
+	n := int32(p.numClasses)
+	state := int32(0)
+	for _, ch := range b {
+		state = p.next[state*n+int32(p.classes[ch])]
+		for _, l := range p.outputs[state] {
+			if !res.foundLits[l] {
+				res.foundLits[l] = true
+				for _, id := range p.owners[l] {
+					res.mayMatch[id] = true
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package matcher
+
+import (
+	"testing"
+)
+
+func TestPrefilter(t *testing.T) {
+	patterns := []struct {
+		pattern string
+		flags   Flags
+	}{
+		{"ERROR", NoFlags},          // 0
+		{"(?:WARN|INFO):", NoFlags}, // 1
+		{"\\d+", NoFlags},           // 2: No literals.
+		{"{!}DEBUG", NoFlags},       // 3: Negated.
+		{"kelvin", IgnoreCase},      // 4
+		{"①error", NoFlags},         // 5
+		{"she", NoFlags},            // 6
+		{"hers", NoFlags},           // 7
+		{"ERROR", IgnoreCase},       // 8: Same literal as 0.
+		{"a(?=b)", NoFlags},         // 9: PCRE-only.
+		{"(?i)straße", NoFlags},     // 10: Non-ASCII letter; no literals.
+	}
+	tests := []struct {
+		target   string
+		mayMatch []int
+	}{
+		{"", []int{2, 3, 9, 10}},
+		{"nothing here", []int{2, 3, 9, 10}},
+		{"an ERROR", []int{0, 2, 3, 8, 9, 10}},
+		{"an error", []int{0, 2, 3, 8, 9, 10}},
+		{"WARN: and INFO:", []int{1, 2, 3, 9, 10}},
+		{"WARN without colon", []int{1, 2, 3, 9, 10}}, // The literals are "WARN" and "INFO".
+		{"KELVIN", []int{2, 3, 4, 9, 10}},
+		{"①ERROR", []int{0, 2, 3, 5, 8, 9, 10}},
+		{"\xff①error\xfe", []int{0, 2, 3, 5, 8, 9, 10}},
+		{"ushers", []int{2, 3, 6, 7, 9, 10}},
+		{"usher", []int{2, 3, 6, 9, 10}},
+	}
+
+	p := NewPrefilter()
+	for i, v := range patterns {
+		m, err := CompilePcre(v.pattern, v.flags)
+		if err != nil {
+			t.Fatalf("p='%s' -> pattern expected to compile, but it didn't: %s", v.pattern, err)
+		}
+		if id := p.Add(m); id != i {
+			t.Fatalf("p='%s' -> ID must be %d, but was %d", v.pattern, i, id)
+		}
+	}
+	p.Build()
+
+	res := PrefilterResult{}
+	target := NewTarget(nil)
+	for _, v := range tests {
+		target.Reset([]byte(v.target))
+		p.Scan(target, &res)
+
+		expected := make([]bool, p.NumMatchers())
+		for _, id := range v.mayMatch {
+			expected[id] = true
+		}
+		for id := 0; id < p.NumMatchers(); id++ {
+			if res.MayMatch(id) != expected[id] {
+				t.Errorf("t='%s' p='%s' -> MayMatch must be %v", v.target, patterns[id].pattern, expected[id])
+			}
+		}
+	}
+}
+
+// TestPrefilter_Samples makes sure the prefilter never skips a matcher that matches, using
+// the sample rules and log.
+func TestPrefilter_Samples(t *testing.T) {
+	patterns, lines := loadSamples(t)
+	for _, flags := range []Flags{NoFlags, IgnoreCase} {
+		p := NewPrefilter()
+		matchers := make([]Matcher, 0, len(patterns))
+		for _, pattern := range patterns {
+			m, err := CompilePcre(pattern, flags)
+			if err != nil {
+				t.Fatalf("%s: %s", pattern, err)
+			}
+			p.Add(m)
+			matchers = append(matchers, m)
+		}
+		p.Build()
+
+		res := PrefilterResult{}
+		target := NewTarget(nil)
+		for _, line := range lines {
+			target.Reset(line)
+			p.Scan(target, &res)
+			for id, m := range matchers {
+				if !res.MayMatch(id) && m.Matches(target) != nil {
+					t.Errorf("t='%s' p='%s' -> skipped by the prefilter, but matches", line, m)
+				}
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package matcher
 
 import (
 	"regexp"
+	"unicode/utf8"
 )
 
 type Flags int
@@ -10,7 +11,14 @@ type matcherGo struct {
 	srcPattern  string
 	realPattern string
 	negate      bool
+	word        bool
+	literals    []string
 	pattern     *regexp.Regexp
+
+	// wordStart and wordNext match whole words for the 'w' prefix, at the start of the target,
+	// and after a non-word character, respectively. See findWords().
+	wordStart *regexp.Regexp
+	wordNext  *regexp.Regexp
 }
 
 var _ = Matcher((*matcherGo)(nil))
@@ -19,33 +27,129 @@ func (r *matcherGo) String() string {
 	return r.srcPattern
 }
 
-func CompileGo(pattern string, flags Flags) (Matcher, error) {
-	negate := false
+// goFlags returns the flag group to prepend to a pattern for the prefix, e.g. "(?is)".
+func goFlags(p prefix) string {
+	var f []byte
+	if p.ignoreCase {
+		f = append(f, 'i')
+	}
+	if p.multiline {
+		f = append(f, 'm')
+	}
+	if p.dotAll {
+		f = append(f, 's')
+	}
+	if len(f) == 0 {
+		return ""
+	}
+	return "(?" + string(f) + ")"
+}
 
-	realPattern := pattern
-	err := preProcess(&realPattern, &negate)
+func CompileGo(pattern string, flags Flags) (Matcher, error) {
+	realPattern, prefix, err := preProcess(pattern, flags)
 	if err != nil {
 		return nil, err
 	}
-	if (flags & IgnoreCase) != 0 {
-		realPattern = "(?i)" + realPattern
+	if prefix.fixed {
+		return newMatcherFixed(pattern, realPattern, prefix), nil
 	}
+	realPattern = goFlags(prefix) + realPattern
 	pat, err := regexp.Compile(realPattern)
 	if err != nil {
 		return nil, err
 	}
 
-	return &matcherGo{srcPattern: pattern, realPattern: realPattern, negate: negate, pattern: pat}, nil
+	ret := &matcherGo{srcPattern: pattern, realPattern: realPattern, negate: prefix.negate, word: prefix.word, pattern: pat}
+	if prefix.word {
+		// Go's engine doesn't support lookarounds, so these consume the characters around a
+		// word instead. The trailing one is captured by an extra group, which is removed later.
+		ret.wordStart, err = regexp.Compile(`\A(?:` + realPattern + `)` + goWordEnd)
+		if err != nil {
+			return nil, err
+		}
+		ret.wordNext, err = regexp.Compile(`\A(?s:.)(?:` + realPattern + `)` + goWordEnd)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !prefix.negate {
+		ret.literals = extractLiterals(realPattern, NoFlags)
+	}
+	return ret, nil
+}
+
+// goWordEnd matches the end of the target or a non-word character after a whole word.
+const goWordEnd = `($|[^\p{L}\p{Nd}_])`
+
+// findAll returns all the matches, only the whole words when needed.
+func (r *matcherGo) findAll(target []byte) [][]int {
+	if !r.word {
+		return r.pattern.FindAllSubmatchIndex(target, -1)
+	}
+	if !r.pattern.Match(target) {
+		return nil
+	}
+	return r.findWords(target)
+}
+
+// findWords returns the matches that are whole words, like "grep -w". Unlike filtering the
+// leftmost matches, it tries each position where a word can start, so "{w}foo|foobar" finds
+// "foobar" in "foobar x".
+func (r *matcherGo) findWords(target []byte) [][]int {
+	var ret [][]int
+	for pos := 0; pos <= len(target); {
+		var m []int
+		if pos == 0 {
+			m = r.wordStart.FindSubmatchIndex(target)
+		} else if !isWordByteBefore(target, pos) {
+			// Match with the previous character, so anchors and \b see it.
+			_, size := utf8.DecodeLastRune(target[:pos])
+			if m = r.wordNext.FindSubmatchIndex(target[pos-size:]); m != nil {
+				for i := range m {
+					if m[i] >= 0 {
+						m[i] += pos - size
+					}
+				}
+			}
+		}
+		if m != nil {
+			// Drop the group for the trailing character.
+			end := m[len(m)-2]
+			m = m[:len(m)-2]
+			m[0] = pos
+			m[1] = end
+			ret = append(ret, m)
+			if end > pos {
+				pos = end
+				continue
+			}
+		}
+		if pos == len(target) {
+			break
+		}
+		_, size := utf8.DecodeRune(target[pos:])
+		pos += size
+	}
+	return ret
+}
+
+// matchesAny returns whether there's any match.
+func (r *matcherGo) matchesAny(target []byte) bool {
+	if !r.word {
+		return r.pattern.Match(target)
+	}
+	return r.findAll(target) != nil
 }
 
-func (r *matcherGo) Matches(target []byte) [][]int {
+func (r *matcherGo) Matches(t *Target) [][]int {
+	target := t.Bytes()
 	if r.negate {
-		if !r.pattern.Match(target) {
+		if !r.matchesAny(target) {
 			return [][]int{{0, len(target)}}
 		}
 		return nil
 	}
-	matches := r.pattern.FindAllSubmatchIndex(target, -1)
+	matches := r.findAll(target)
 	if len(matches) == 0 {
 		return nil
 	}
@@ -57,8 +161,31 @@ func (r *matcherGo) Matches(target []byte) [][]int {
 	ret := make([][]int, 0, captures*len(matches))
 	for i := 0; i < len(matches); i++ {
 		for j := 0; j < captures; j++ {
+			// Skip groups that didn't participate in the match, e.g. "(x)?", the same as PCRE.
+			if matches[i][2+j*2] < 0 {
+				continue
+			}
 			ret = append(ret, []int{matches[i][2+j*2], matches[i][2+j*2+1]})
 		}
 	}
 	return ret
 }
+
+func (r *matcherGo) FindAllSubmatchIndex(t *Target) [][]int {
+	target := t.Bytes()
+	if r.negate {
+		if !r.matchesAny(target) {
+			return negatedMatch(target, r.pattern.NumSubexp())
+		}
+		return nil
+	}
+	return r.findAll(target)
+}
+
+func (r *matcherGo) SubexpNames() []string {
+	return r.pattern.SubexpNames()
+}
+
+func (r *matcherGo) RequiredLiterals() []string {
+	return r.literals
+}
@@ -1,6 +1,8 @@
 package matcher
 
 import (
+	"strconv"
+
 	"github.com/dlclark/regexp2"
 )
 
@@ -8,6 +10,7 @@ type matcherPcre struct {
 	srcPattern  string
 	realPattern string
 	negate      bool
+	literals    []string
 	pattern     *regexp2.Regexp
 }
 
@@ -18,72 +21,173 @@ func (r *matcherPcre) String() string {
 }
 
 func CompilePcre(pattern string, flags Flags) (Matcher, error) {
-	negate := false
-
-	realPattern := pattern
-	err := preProcess(&realPattern, &negate)
+	realPattern, prefix, err := preProcess(pattern, flags)
 	if err != nil {
 		return nil, err
 	}
+	if prefix.fixed {
+		return newMatcherFixed(pattern, realPattern, prefix), nil
+	}
 	var re2Flags regexp2.RegexOptions
-	if (flags & IgnoreCase) != 0 {
+	if prefix.ignoreCase {
 		re2Flags |= regexp2.IgnoreCase
 	}
-	pat, err := regexp2.Compile(realPattern, re2Flags)
+	if prefix.multiline {
+		re2Flags |= regexp2.Multiline
+	}
+	if prefix.dotAll {
+		re2Flags |= regexp2.Singleline
+	}
+	compiled := realPattern
+	if prefix.word {
+		compiled = pcreWordStart + "(?:" + realPattern + ")" + pcreWordEnd
+	}
+	pat, err := regexp2.Compile(compiled, re2Flags)
 	if err != nil {
 		return nil, err
 	}
+	if MatchTimeout > 0 {
+		pat.MatchTimeout = MatchTimeout
+	}
 
-	return &matcherPcre{srcPattern: pattern, realPattern: realPattern, negate: negate, pattern: pat}, nil
+	ret := &matcherPcre{srcPattern: pattern, realPattern: realPattern, negate: prefix.negate, pattern: pat}
+	if !prefix.negate {
+		ret.literals = pcreLiterals(pattern, flags, ret)
+	}
+	return ret, nil
 }
 
-func (r *matcherPcre) Matches(target []byte) [][]int {
-	s := string(target)
-
-	// regexp2 returns rune positions; build mapping to byte offsets
-	runeToBytePos := make([]int, 0, len(s))
-	for bytePos := range s {
-		runeToBytePos = append(runeToBytePos, bytePos)
+// pcreLiterals returns the required literals of a PCRE pattern. They're extracted with Go's
+// syntax, so only when Go's engine gives the same results, at least on ASCII lines, which
+// differ only in assertions and case folding, which don't change the literals.
+func pcreLiterals(pattern string, flags Flags, p *matcherPcre) []string {
+	gm, err := CompileGo(pattern, flags|UnicodeClasses)
+	if err != nil {
+		return nil
+	}
+	g := gm.(*matcherGo)
+	if goSupport(g, p) == pcreOnly {
+		return nil
 	}
-	runeToBytePos = append(runeToBytePos, len(target))
+	return g.literals
+}
 
-	byteOffset := func(runeIdx int) int {
-		if runeIdx >= 0 && runeIdx < len(runeToBytePos) {
-			return runeToBytePos[runeIdx]
-		}
-		return len(target)
+// pcreWordStart and pcreWordEnd make a pattern match only whole words, for the 'w' prefix.
+const (
+	pcreWordStart = `(?<![\p{L}\p{Nd}_])`
+	pcreWordEnd   = `(?![\p{L}\p{Nd}_])`
+)
+
+// findFirst returns the first match.
+func (r *matcherPcre) findFirst(t *Target) (*regexp2.Match, error) {
+	return convertPcreResult(r.pattern.FindRunesMatch(t.Runes()))
+}
+
+// findNext returns the next match.
+func (r *matcherPcre) findNext(t *Target, m *regexp2.Match) (*regexp2.Match, error) {
+	return convertPcreResult(r.pattern.FindNextMatch(m))
+}
+
+func convertPcreResult(m *regexp2.Match, err error) (*regexp2.Match, error) {
+	if err != nil {
+		// regexp2 only fails on timeouts. Its error contains the entire input, so don't use it.
+		return nil, ErrTimeout
 	}
+	return m, nil
+}
+
+// matchesAny returns whether there's any match.
+func (r *matcherPcre) matchesAny(t *Target) (bool, error) {
+	m, err := r.findFirst(t)
+	return m != nil, err
+}
 
+func (r *matcherPcre) Matches(t *Target) [][]int {
 	if r.negate {
-		m, _ := r.pattern.FindStringMatch(s)
-		if m == nil {
-			return [][]int{{0, len(target)}}
+		found, err := r.matchesAny(t)
+		if err != nil {
+			t.err = err
+			return nil
+		}
+		if !found {
+			return [][]int{{0, len(t.Bytes())}}
 		}
 		return nil
 	}
 
-	res := make([][]int, 0)
-	for m, _ := r.pattern.FindStringMatch(s); m != nil; m, _ = r.pattern.FindNextMatch(m) {
+	var res [][]int
+	m, err := r.findFirst(t)
+	for ; m != nil; m, err = r.findNext(t, m) {
 		groups := m.Groups()
 		if len(groups) <= 1 {
 			res = append(res, []int{
-				byteOffset(groups[0].Index),
-				byteOffset(groups[0].Index + groups[0].Length),
+				t.ByteOffset(groups[0].Index),
+				t.ByteOffset(groups[0].Index + groups[0].Length),
 			})
 		} else {
 			for _, g := range groups[1:] {
 				if len(g.Captures) > 0 {
 					res = append(res, []int{
-						byteOffset(g.Index),
-						byteOffset(g.Index + g.Length),
+						t.ByteOffset(g.Index),
+						t.ByteOffset(g.Index + g.Length),
 					})
 				}
 			}
 		}
 	}
+	if err != nil {
+		t.err = err
+		return nil
+	}
+	return res
+}
+
+func (r *matcherPcre) FindAllSubmatchIndex(t *Target) [][]int {
+	if r.negate {
+		found, err := r.matchesAny(t)
+		if err != nil {
+			t.err = err
+			return nil
+		}
+		if !found {
+			return negatedMatch(t.Bytes(), len(r.pattern.GetGroupNumbers())-1)
+		}
+		return nil
+	}
 
-	if len(res) == 0 {
+	var res [][]int
+	m, err := r.findFirst(t)
+	for ; m != nil; m, err = r.findNext(t, m) {
+		groups := m.Groups()
+		indexes := make([]int, 0, len(groups)*2)
+		for _, g := range groups {
+			if len(g.Captures) == 0 {
+				indexes = append(indexes, -1, -1)
+				continue
+			}
+			indexes = append(indexes, t.ByteOffset(g.Index), t.ByteOffset(g.Index+g.Length))
+		}
+		res = append(res, indexes)
+	}
+	if err != nil {
+		t.err = err
 		return nil
 	}
 	return res
 }
+
+func (r *matcherPcre) SubexpNames() []string {
+	numbers := r.pattern.GetGroupNumbers()
+	names := make([]string, len(numbers))
+	for i, n := range numbers {
+		// Unnamed groups are named after their numbers.
+		if name := r.pattern.GroupNameFromNumber(n); name != strconv.Itoa(n) {
+			names[i] = name
+		}
+	}
+	return names
+}
+
+func (r *matcherPcre) RequiredLiterals() []string {
+	return r.literals
+}
@@ -2,7 +2,9 @@ package matcher
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -10,14 +12,16 @@ const (
 	Error   = false
 )
 
+type matchTest struct {
+	pattern  string
+	target   string
+	result   [][]int
+	flags    Flags
+	compiles bool
+}
+
 func TestRegex_Matches(t *testing.T) {
-	tests := []struct {
-		pattern  string
-		target   string
-		result   [][]int
-		flags    Flags
-		compiles bool
-	}{
+	tests := []matchTest{
 		{"", "", [][]int{{0, 0}}, NoFlags, NoError},
 		{"x", "y", nil, NoFlags, NoError},
 		{"(", "y", nil, NoFlags, Error},
@@ -34,9 +38,22 @@ func TestRegex_Matches(t *testing.T) {
 		{"x(y)x(z)", "xyxzYYxyxz", [][]int{{1, 2}, {3, 4}, {7, 8}, {9, 10}}, NoFlags, NoError},
 		{"y", "xyzXYZ", [][]int{{1, 2}, {4, 5}}, IgnoreCase, NoError},
 		{"{#}x y z", "xyz", [][]int{{0, 3}}, IgnoreCase, NoError},
+		{"③(④)", "①②③④⑤", [][]int{{9, 12}}, NoFlags, NoError},
+		{"b", "\xffab\xfeb", [][]int{{2, 3}, {4, 5}}, NoFlags, NoError},
+		{"x(y)?z", "xzxyz", [][]int{{3, 4}}, NoFlags, NoError},
+		{"\\q", "q", nil, NoFlags, Error},
+		{"\\y+", "yy", nil, NoFlags, Error},
 	}
+	for _, compile := range []func(string, Flags) (Matcher, error){CompileGo, CompilePcre, CompileAuto} {
+		testMatches(t, compile, tests)
+	}
+}
+
+func testMatches(t *testing.T, compile func(string, Flags) (Matcher, error), tests []matchTest) {
+	// Reuse the same target, like Runtime does.
+	target := NewTarget(nil)
 	for _, v := range tests {
-		re, err := CompileGo(v.pattern, v.flags)
+		re, err := compile(v.pattern, v.flags)
 		if !v.compiles {
 			if re != nil {
 				t.Errorf("p='%s' t='%s' -> re must be null", v.pattern, v.target)
@@ -50,7 +67,8 @@ func TestRegex_Matches(t *testing.T) {
 			t.Errorf("p='%s' t='%s' -> pattern expected to complie, but it didn't: %s", v.pattern, v.target, err)
 			continue
 		}
-		res := re.Matches([]byte(v.target))
+		target.Reset([]byte(v.target))
+		res := re.Matches(target)
 		if res == nil && v.result == nil {
 			continue
 		}
@@ -59,3 +77,226 @@ func TestRegex_Matches(t *testing.T) {
 		}
 	}
 }
+
+func TestFindAllSubmatchIndex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		target  string
+		result  [][]int
+		names   []string
+	}{
+		{"x", "y", nil, []string{""}},
+		{"x", "xyx", [][]int{{0, 1}, {2, 3}}, []string{""}},
+		{"x(y)", "xyzxy", [][]int{{0, 2, 1, 2}, {3, 5, 4, 5}}, []string{"", ""}},
+		{"x(y)?z", "xzxyz", [][]int{{0, 2, -1, -1}, {2, 5, 3, 4}}, []string{"", ""}},
+		{"(?P<id>\\d+)", "a12b3", [][]int{{1, 3, 1, 3}, {4, 5, 4, 5}}, []string{"", "id"}},
+		{"①(②)", "x①②", [][]int{{1, 7, 4, 7}}, []string{"", ""}},
+		{"{!}(x)", "abc", [][]int{{0, 3, -1, -1}}, []string{"", ""}},
+		{"{!}(x)", "xyz", nil, []string{"", ""}},
+	}
+	// regexp2 doesn't support the (?P<name>) syntax.
+	compilePcre := func(pattern string, flags Flags) (Matcher, error) {
+		return CompilePcre(strings.ReplaceAll(pattern, "(?P<", "(?<"), flags)
+	}
+	compileAuto := func(pattern string, flags Flags) (Matcher, error) {
+		return CompileAuto(strings.ReplaceAll(pattern, "(?P<", "(?<"), flags)
+	}
+	for _, compile := range []func(string, Flags) (Matcher, error){CompileGo, compilePcre, compileAuto} {
+		for _, v := range tests {
+			re, err := compile(v.pattern, NoFlags)
+			if err != nil {
+				t.Errorf("p='%s' -> pattern expected to compile, but it didn't: %s", v.pattern, err)
+				continue
+			}
+			res := re.FindAllSubmatchIndex(NewTarget([]byte(v.target)))
+			if !reflect.DeepEqual(res, v.result) {
+				t.Errorf("p='%s' t='%s' -> result must be %+v, but was %+v", v.pattern, v.target, v.result, res)
+			}
+			if names := re.SubexpNames(); !reflect.DeepEqual(names, v.names) {
+				t.Errorf("p='%s' -> names must be %q, but was %q", v.pattern, v.names, names)
+			}
+		}
+	}
+}
+
+func TestRegex_FixedAndWord(t *testing.T) {
+	tests := []matchTest{
+		{"{=}a.c", "abc a.c", [][]int{{4, 7}}, NoFlags, NoError},
+		{"{=}(", "x(y(", [][]int{{1, 2}, {3, 4}}, NoFlags, NoError},
+		{"{=}A.C", "a.c A.C", [][]int{{0, 3}, {4, 7}}, IgnoreCase, NoError},
+		{"{=}straße", "STRASSE Straße", [][]int{{8, 15}}, IgnoreCase, NoError},
+		{"{=}k", "K", [][]int{{0, 3}}, IgnoreCase, NoError},
+		{"{!=}a.c", "abc", [][]int{{0, 3}}, NoFlags, NoError},
+		{"{!=}a.c", "a.c", nil, NoFlags, NoError},
+		{"{=#} a b ", "a b ab", [][]int{{4, 6}}, NoFlags, NoError},
+		{"a.c", "abc a.c", [][]int{{4, 7}}, FixedString, NoError},
+
+		{"{w}err", "err error _err err.", [][]int{{0, 3}, {15, 18}}, NoFlags, NoError},
+		{"{w}\\d+", "a1 22 3b 44", [][]int{{3, 5}, {9, 11}}, NoFlags, NoError},
+		{"{w}a(b)", "ab xab ab", [][]int{{1, 2}, {8, 9}}, NoFlags, NoError},
+		{"{w}été", "été, étés", [][]int{{0, 5}}, NoFlags, NoError},
+		{"{w}-x", "a-x -x", [][]int{{4, 6}}, NoFlags, NoError},
+		{"{!w}err", "error", [][]int{{0, 5}}, NoFlags, NoError},
+		{"{!w}err", "an err", nil, NoFlags, NoError},
+		{"{w=}a.c", "a.cd a.c", [][]int{{5, 8}}, NoFlags, NoError},
+		{"err", "error err", [][]int{{6, 9}}, WholeWord, NoError},
+		{"{w}foo|foobar", "foobar x", [][]int{{0, 6}}, NoFlags, NoError},
+		{"{w}a+", "baa aaa", [][]int{{4, 7}}, NoFlags, NoError},
+		{"{w}(a)|(b)", "b ab a", [][]int{{0, 1}, {5, 6}}, NoFlags, NoError},
+		{"{w=}aa", "aaa aa", [][]int{{4, 6}}, NoFlags, NoError},
+		{"{w}^foo", "x foo", nil, NoFlags, NoError},
+		{"{wm}^foo", "x\nfoo bar", [][]int{{2, 5}}, NoFlags, NoError},
+		{"{w}\\bfoo", "afoo foo", [][]int{{5, 8}}, NoFlags, NoError},
+		{"{x}err", "err", nil, NoFlags, Error},
+	}
+	for _, compile := range []func(string, Flags) (Matcher, error){CompileGo, CompilePcre, CompileAuto} {
+		testMatches(t, compile, tests)
+	}
+}
+
+func TestRegex_PrefixFlags(t *testing.T) {
+	tests := []matchTest{
+		{"{i}err", "ERR err", [][]int{{0, 3}, {4, 7}}, NoFlags, NoError},
+		{"{I}err", "ERR err", [][]int{{4, 7}}, IgnoreCase, NoError},
+		{"{iI}err", "ERR err", [][]int{{4, 7}}, NoFlags, NoError},
+		{"{!i}err", "ERR", nil, NoFlags, NoError},
+		{"{=i}a.C", "A.c", [][]int{{0, 3}}, NoFlags, NoError},
+		{"{=I}a.C", "A.c", nil, IgnoreCase, NoError},
+		{"{m}^b$", "a\nb\nc", [][]int{{2, 3}}, NoFlags, NoError},
+		{"^b$", "a\nb\nc", nil, NoFlags, NoError},
+		{"{s}a.b", "a\nb", [][]int{{0, 3}}, NoFlags, NoError},
+		{"a.b", "a\nb", nil, NoFlags, NoError},
+		{"{is}A.B", "a\nb", [][]int{{0, 3}}, NoFlags, NoError},
+
+		// {u} makes character classes Unicode-aware with Go's engine. PCRE's already are.
+		{"{u}\\d+", "12３４", [][]int{{0, 8}}, NoFlags, NoError},
+		{"{u}\\w+", "café x", [][]int{{0, 5}, {6, 7}}, NoFlags, NoError},
+		{"{u}\\W", "é-x", [][]int{{2, 3}}, NoFlags, NoError},
+		{"{u}[\\w-]+", "é-x", [][]int{{0, 4}}, NoFlags, NoError},
+		{"{u}[^\\d]", "３x", [][]int{{3, 4}}, NoFlags, NoError},
+		{"{u}\\s", "a\u3000b\vc", [][]int{{1, 4}, {5, 6}}, NoFlags, NoError},
+		{"{u}\\\\w", "\\w", [][]int{{0, 2}}, NoFlags, NoError},
+	}
+	for _, compile := range []func(string, Flags) (Matcher, error){CompileGo, CompilePcre, CompileAuto} {
+		testMatches(t, compile, tests)
+	}
+}
+
+func TestRegex_Classes(t *testing.T) {
+	unicodeTests := []matchTest{
+		{"\\d+", "12３４", [][]int{{0, 8}}, NoFlags, NoError},
+		{"\\w+", "café", [][]int{{0, 5}}, NoFlags, NoError},
+		{"\\s", "a\u3000b\vc", [][]int{{1, 4}, {5, 6}}, NoFlags, NoError},
+		{"[\\W]+", "é-x", [][]int{{2, 3}}, NoFlags, NoError},
+	}
+	for _, compile := range []func(string, Flags) (Matcher, error){CompilePcre, CompileAuto} {
+		testMatches(t, compile, unicodeTests)
+	}
+
+	// Go's engine only supports ASCII classes and POSIX classes, unless with {u}.
+	asciiTests := []matchTest{
+		{"\\d+", "12３４", [][]int{{0, 2}}, NoFlags, NoError},
+		{"\\w+", "café", [][]int{{0, 3}}, NoFlags, NoError},
+		{"\\s", "a\u3000b\vc", nil, NoFlags, NoError},
+		{"{u}[[:alpha:]\\d]+", "ab３", [][]int{{0, 5}}, NoFlags, NoError},
+	}
+	testMatches(t, CompileGo, asciiTests)
+}
+
+func TestUnicodeClasses(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"abc", "abc"},
+		{"\\w+\\d", "[\\p{L}\\p{Mn}\\p{Nd}\\p{Pc}\\x{200C}\\x{200D}]+\\p{Nd}"},
+		{"[\\w.]", "[\\p{L}\\p{Mn}\\p{Nd}\\p{Pc}\\x{200C}\\x{200D}.]"},
+		{"[\\W]", "[\\W]"},
+		{"[]\\s]\\S", "[]\\t\\n\\v\\f\\r\\x20\\x85\\p{Z}][^\\t\\n\\v\\f\\r\\x20\\x85\\p{Z}]"},
+		{"[^]\\d]", "[^]\\p{Nd}]"},
+		{"[[:digit:]]\\d", "[[:digit:]]\\p{Nd}"},
+		{"\\Q\\d\\E\\d", "\\Q\\d\\E\\p{Nd}"},
+		{"\\Q\\d", "\\Q\\d"},
+		{"\\\\d", "\\\\d"},
+	}
+	for _, v := range tests {
+		if actual := unicodeClasses(v.pattern); actual != v.expected {
+			t.Errorf("p='%s' -> must be '%s', but was '%s'", v.pattern, v.expected, actual)
+		}
+	}
+}
+
+func TestQuoteMeta(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flags   Flags
+		s       string
+	}{
+		{"x", NoFlags, "a.b*c"},
+		{"{=}x", NoFlags, "a.b*c"},
+		{"x", FixedString, "a.b*c"},
+		{"{#}x", NoFlags, "a b.c"},
+		{"{=#}x", NoFlags, "a b.c"},
+		{"{i}x", NoFlags, "(A|b)"},
+	}
+	for _, v := range tests {
+		pattern := v.pattern + QuoteMeta(v.pattern, v.flags, v.s) + "y"
+		for _, compile := range []func(string, Flags) (Matcher, error){CompileGo, CompilePcre, CompileAuto} {
+			m, err := compile(pattern, v.flags)
+			if err != nil {
+				t.Errorf("p='%s' must compile, but got %s", pattern, err)
+				continue
+			}
+			target := "x" + v.s + "y"
+			expected := [][]int{{0, len(target)}}
+			if actual := m.Matches(NewTarget([]byte(target))); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("p='%s' t='%s' -> must be %v, but was %v", pattern, target, expected, actual)
+			}
+		}
+	}
+}
+
+func TestPcre_MatchTimeout(t *testing.T) {
+	MatchTimeout = 10 * time.Millisecond
+	defer func() { MatchTimeout = 0 }()
+
+	target := NewTarget([]byte(strings.Repeat("a", 40) + "c"))
+	for _, pattern := range []string{"(a+)+b", "{!}(a+)+b"} {
+		m, err := CompilePcre(pattern, NoFlags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res := m.Matches(target); res != nil {
+			t.Errorf("p='%s' -> result must be nil, but was %+v", pattern, res)
+		}
+		if err := target.TakeErr(); err != ErrTimeout {
+			t.Errorf("p='%s' -> error must be ErrTimeout, but was %v", pattern, err)
+		}
+		if res := m.FindAllSubmatchIndex(target); res != nil {
+			t.Errorf("p='%s' -> result must be nil, but was %+v", pattern, res)
+		}
+		if err := target.TakeErr(); err != ErrTimeout {
+			t.Errorf("p='%s' -> error must be ErrTimeout, but was %v", pattern, err)
+		}
+	}
+
+	// Normal matches don't fail.
+	m, _ := CompilePcre("a+c", NoFlags)
+	if res := m.Matches(target); !reflect.DeepEqual(res, [][]int{{0, 41}}) {
+		t.Errorf("result must be [[0 41]], but was %+v", res)
+	}
+	if err := target.TakeErr(); err != nil {
+		t.Errorf("error must be nil, but was %v", err)
+	}
+}
+
+func TestIsFixedString(t *testing.T) {
+	for pattern, expected := range map[string]bool{"x": false, "{=}x": true, "{w=}x": true, "{w}x": false, "{x": false} {
+		if actual := IsFixedString(pattern, NoFlags); actual != expected {
+			t.Errorf("p='%s' -> must be %v, but was %v", pattern, expected, actual)
+		}
+	}
+	if !IsFixedString("x", FixedString) {
+		t.Errorf("p='x' with FixedString -> must be true")
+	}
+}
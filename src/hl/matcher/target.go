@@ -0,0 +1,120 @@
+package matcher
+
+import "unicode/utf8"
+
+// Target is a line to match against. It's shared by all the matchers for the same line, so
+// conversions that matchers need, such as into runes for PCRE, are done only once per line.
+// A Target can be reused for the next line with Reset(), which also reuses its buffers.
+type Target struct {
+	bytes []byte
+
+	runesReady bool
+	runes      []rune
+
+	// asciiState caches IsASCII(); 0 if unknown, 1 if ASCII, and 2 otherwise.
+	asciiState int
+
+	// ascii is true if every rune is a single byte, in which case rune indexes are byte offsets.
+	ascii bool
+
+	// runeOffsets[i] is the byte offset of runes[i], followed by the length of bytes.
+	// Only used when ascii is false.
+	runeOffsets []int
+
+	// err is the error from a matcher, such as ErrTimeout.
+	err error
+}
+
+// NewTarget creates a new Target.
+func NewTarget(b []byte) *Target {
+	t := &Target{}
+	t.Reset(b)
+	return t
+}
+
+// Reset sets a new line.
+func (t *Target) Reset(b []byte) {
+	t.bytes = b
+	t.runesReady = false
+	t.asciiState = 0
+	t.err = nil
+}
+
+// Bytes returns the line.
+func (t *Target) Bytes() []byte {
+	return t.bytes
+}
+
+func (t *Target) prepareRunes() {
+	if t.runesReady {
+		return
+	}
+	t.runesReady = true
+	t.runes = t.runes[:0]
+	t.runeOffsets = t.runeOffsets[:0]
+	t.ascii = true
+
+	for i := 0; i < len(t.bytes); {
+		ch := t.bytes[i]
+		if ch < utf8.RuneSelf && t.ascii {
+			t.runes = append(t.runes, rune(ch))
+			i++
+			continue
+		}
+		if t.ascii {
+			// First non-ASCII byte; all the runes so far are single bytes.
+			t.ascii = false
+			for j := 0; j < i; j++ {
+				t.runeOffsets = append(t.runeOffsets, j)
+			}
+		}
+		r, size := utf8.DecodeRune(t.bytes[i:])
+		t.runes = append(t.runes, r)
+		t.runeOffsets = append(t.runeOffsets, i)
+		i += size
+	}
+	if !t.ascii {
+		t.runeOffsets = append(t.runeOffsets, len(t.bytes))
+	}
+}
+
+// IsASCII returns whether the line only has ASCII characters.
+func (t *Target) IsASCII() bool {
+	if t.asciiState == 0 {
+		t.asciiState = 1
+		if hasNonAscii(t.bytes) {
+			t.asciiState = 2
+		}
+	}
+	return t.asciiState == 1
+}
+
+// Runes returns the line as runes. Invalid bytes are converted into utf8.RuneError, the same
+// way as converting a string into []rune.
+func (t *Target) Runes() []rune {
+	t.prepareRunes()
+	return t.runes
+}
+
+// ByteOffset converts a rune index into Runes() into a byte offset into Bytes().
+func (t *Target) ByteOffset(runeIndex int) int {
+	t.prepareRunes()
+	if t.ascii {
+		if runeIndex >= 0 && runeIndex < len(t.bytes) {
+			return runeIndex
+		}
+		return len(t.bytes)
+	}
+	if runeIndex >= 0 && runeIndex < len(t.runeOffsets) {
+		return t.runeOffsets[runeIndex]
+	}
+	return len(t.bytes)
+}
+
+// TakeErr returns the error from the matchers since the last call, such as ErrTimeout, and
+// clears it. A matcher returns no matches when it fails.
+func (t *Target) TakeErr() error {
+	err := t.err
+	t.err = nil
+	return err
+}
@@ -0,0 +1,57 @@
+package matcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTarget(t *testing.T) {
+	target := NewTarget([]byte("abc"))
+	assert.Equal(t, []byte("abc"), target.Bytes())
+	assert.Equal(t, []rune("abc"), target.Runes())
+	assert.Equal(t, 0, target.ByteOffset(0))
+	assert.Equal(t, 2, target.ByteOffset(2))
+	assert.Equal(t, 3, target.ByteOffset(3))
+	assert.Equal(t, 3, target.ByteOffset(4))
+	assert.Equal(t, 3, target.ByteOffset(-1))
+
+	target.Reset([]byte("a①b②"))
+	assert.Equal(t, []rune("a①b②"), target.Runes())
+	assert.Equal(t, 0, target.ByteOffset(0))
+	assert.Equal(t, 1, target.ByteOffset(1))
+	assert.Equal(t, 4, target.ByteOffset(2))
+	assert.Equal(t, 5, target.ByteOffset(3))
+	assert.Equal(t, 8, target.ByteOffset(4))
+	assert.Equal(t, 8, target.ByteOffset(5))
+
+	// Invalid bytes are converted the same way as string -> []rune.
+	invalid := "x\xff\xe2\x91y"
+	target.Reset([]byte(invalid))
+	assert.Equal(t, []rune(invalid), target.Runes())
+	offsets := []int{}
+	for i := range invalid {
+		offsets = append(offsets, i)
+	}
+	for i, o := range offsets {
+		assert.Equal(t, o, target.ByteOffset(i))
+	}
+
+	target.Reset(nil)
+	assert.Empty(t, target.Runes())
+	assert.Equal(t, 0, target.ByteOffset(0))
+}
+
+func TestTarget_NoAllocs(t *testing.T) {
+	target := NewTarget(nil)
+	line := []byte("05-12 16:31:46.083  8153  8532 F libc    : ①②③")
+	target.Reset(line)
+	target.Runes()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		target.Reset(line)
+		target.Runes()
+		target.ByteOffset(10)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
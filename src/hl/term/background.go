@@ -0,0 +1,103 @@
+package term
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+)
+
+// Background is the brightness of the terminal background.
+type Background int
+
+const (
+	UnknownBackground Background = iota
+	DarkBackground
+	LightBackground
+)
+
+func (b Background) String() string {
+	switch b {
+	case DarkBackground:
+		return "dark"
+	case LightBackground:
+		return "light"
+	}
+	return "unknown"
+}
+
+// ParseBackground converts "dark", "light" or "auto" into a Background.
+// "auto" results in UnknownBackground.
+func ParseBackground(s string) (Background, error) {
+	switch strings.ToLower(s) {
+	case "dark":
+		return DarkBackground, nil
+	case "light":
+		return LightBackground, nil
+	case "auto", "":
+		return UnknownBackground, nil
+	}
+	return UnknownBackground, fmt.Errorf("invalid background '%s'; must be light, dark or auto", s)
+}
+
+var (
+	osc11ResponseRe = regexp.MustCompile(`\x1b\]11;rgb:([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})/([0-9a-fA-F]{1,4})`)
+)
+
+// DetectBackground detects the terminal background, first by asking the terminal
+// with an OSC 11 query, then using $COLORFGBG. Returns DarkBackground if neither works.
+// The terminal is only queried when both stdout and stdin are terminals.
+func DetectBackground() Background {
+	if isatty.IsTerminal(os.Stdout.Fd()) && isatty.IsTerminal(os.Stdin.Fd()) {
+		resp, err := queryBackgroundColor()
+		if err == nil {
+			if b := parseOsc11Response(resp); b != UnknownBackground {
+				return b
+			}
+		}
+	}
+	if b := parseColorFgBg(os.Getenv("COLORFGBG")); b != UnknownBackground {
+		return b
+	}
+	return DarkBackground
+}
+
+// parseOsc11Response parses a response to an OSC 11 query, such as
+// "ESC ] 11 ; rgb:ffff/ffff/ffff ESC \".
+func parseOsc11Response(resp []byte) Background {
+	m := osc11ResponseRe.FindSubmatch(resp)
+	if m == nil {
+		return UnknownBackground
+	}
+	channel := func(hex []byte) float64 {
+		v, _ := strconv.ParseUint(string(hex), 16, 32)
+		max := uint64(1)<<(4*len(hex)) - 1
+		return float64(v) / float64(max)
+	}
+	// Perceived brightness (ITU-R BT.601)
+	luma := 0.299*channel(m[1]) + 0.587*channel(m[2]) + 0.114*channel(m[3])
+	if luma < 0.5 {
+		return DarkBackground
+	}
+	return LightBackground
+}
+
+// parseColorFgBg parses $COLORFGBG, which is in the form of "FG;BG" or "FG;DEFAULT;BG",
+// where BG is an ANSI color index.
+func parseColorFgBg(value string) Background {
+	fields := strings.Split(value, ";")
+	if len(fields) < 2 {
+		return UnknownBackground
+	}
+	bg, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || bg < 0 || bg > 15 {
+		return UnknownBackground
+	}
+	if bg == 7 || bg >= 9 {
+		return LightBackground
+	}
+	return DarkBackground
+}
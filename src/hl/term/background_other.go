@@ -0,0 +1,9 @@
+//go:build !unix
+
+package term
+
+import "errors"
+
+func queryBackgroundColor() ([]byte, error) {
+	return nil, errors.New("not supported")
+}
@@ -0,0 +1,41 @@
+package term
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOsc11Response(t *testing.T) {
+	assert.Equal(t, DarkBackground, parseOsc11Response([]byte("\x1b]11;rgb:0000/0000/0000\x1b\\\x1b[?62;c")))
+	assert.Equal(t, LightBackground, parseOsc11Response([]byte("\x1b]11;rgb:ffff/ffff/ffff\x07")))
+	assert.Equal(t, LightBackground, parseOsc11Response([]byte("\x1b]11;rgb:fd/f6/e3\x1b\\")))
+	assert.Equal(t, DarkBackground, parseOsc11Response([]byte("\x1b]11;rgb:2828/2c2c/3434\x1b\\")))
+	assert.Equal(t, UnknownBackground, parseOsc11Response([]byte("\x1b[?62;c")))
+}
+
+func TestParseColorFgBg(t *testing.T) {
+	assert.Equal(t, DarkBackground, parseColorFgBg("15;0"))
+	assert.Equal(t, DarkBackground, parseColorFgBg("15;default;8"))
+	assert.Equal(t, LightBackground, parseColorFgBg("0;15"))
+	assert.Equal(t, LightBackground, parseColorFgBg("0;7"))
+	assert.Equal(t, UnknownBackground, parseColorFgBg(""))
+	assert.Equal(t, UnknownBackground, parseColorFgBg("0;default"))
+}
+
+func TestParseBackground(t *testing.T) {
+	b, err := ParseBackground("Light")
+	assert.Nil(t, err)
+	assert.Equal(t, LightBackground, b)
+
+	b, err = ParseBackground("dark")
+	assert.Nil(t, err)
+	assert.Equal(t, DarkBackground, b)
+
+	b, err = ParseBackground("auto")
+	assert.Nil(t, err)
+	assert.Equal(t, UnknownBackground, b)
+
+	_, err = ParseBackground("gray")
+	assert.NotNil(t, err)
+}
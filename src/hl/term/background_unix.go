@@ -0,0 +1,69 @@
+//go:build unix
+
+package term
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+const backgroundQueryTimeout = 200 * time.Millisecond
+
+// queryBackgroundColor sends an OSC 11 query to the terminal, followed by a DA1 query,
+// which all terminals respond to, so we don't have to wait for the timeout on
+// terminals that don't support OSC 11.
+func queryBackgroundColor() ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	fd := int(tty.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer term.Restore(fd, state)
+
+	_, err = tty.WriteString("\x1b]11;?\x1b\\\x1b[c")
+	if err != nil {
+		return nil, err
+	}
+
+	var resp []byte
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(backgroundQueryTimeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return resp, errors.New("timed out waiting for the terminal")
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(remaining/time.Millisecond)+1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return resp, err
+		}
+		if n == 0 {
+			continue
+		}
+		n, err = tty.Read(buf)
+		if err != nil {
+			return resp, err
+		}
+		resp = append(resp, buf[:n]...)
+
+		// The DA1 response ("ESC [ ? ... c") comes last.
+		if i := bytes.Index(resp, []byte("\x1b[?")); i >= 0 && bytes.IndexByte(resp[i:], 'c') >= 0 {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package term
+
+import "github.com/omakoto/hl2/src/hl/colors"
+
+type contrastKey struct {
+	fg    colors.Color
+	bg    colors.Color
+	attrs colors.Attribute
+}
+
+// ContrastAdjuster renders foreground colors adjusted to have a minimum contrast ratio
+// against their background colors.
+type ContrastAdjuster struct {
+	term     Term
+	minRatio float64
+	cache    map[contrastKey][]byte
+}
+
+// NewContrastAdjuster creates a new ContrastAdjuster.
+func NewContrastAdjuster(t Term, minRatio float64) *ContrastAdjuster {
+	return &ContrastAdjuster{term: t, minRatio: minRatio, cache: make(map[contrastKey][]byte)}
+}
+
+// FgCode returns the same code as r.FgCode(), except the foreground color is adjusted when
+// both the foreground and the background colors are known RGB values.
+func (a *ContrastAdjuster) FgCode(r *RenderedColors) []byte {
+	fg, attrs := r.FgColors()
+	if !fg.HasRgb() {
+		return r.FgCode()
+	}
+	bg := r.BgColor()
+	if !bg.HasRgb() {
+		return r.FgCode()
+	}
+	key := contrastKey{fg: fg, bg: bg, attrs: attrs}
+	if code, ok := a.cache[key]; ok {
+		return code
+	}
+	var code []byte
+	adjusted := colors.AdjustContrast(fg, bg, a.minRatio)
+	if adjusted == fg {
+		code = r.FgCode()
+	} else {
+		code = a.term.renderFg(adjusted, attrs)
+	}
+	a.cache[key] = code
+	return code
+}
@@ -0,0 +1,23 @@
+package term
+
+import "bytes"
+
+var (
+	oscLinkStart = []byte("\x1b]8;;")
+	oscEnd       = []byte("\x1b\\")
+	oscLinkEnd   = []byte("\x1b]8;;\x1b\\")
+)
+
+// renderLinkStart returns an OSC 8 sequence that starts a hyperlink. Control characters in
+// the URL are removed, so it can't terminate the sequence.
+func renderLinkStart(url string) []byte {
+	var b bytes.Buffer
+	b.Write(oscLinkStart)
+	for i := 0; i < len(url); i++ {
+		if ch := url[i]; ch >= 0x20 && ch != 0x7f {
+			b.WriteByte(ch)
+		}
+	}
+	b.Write(oscEnd)
+	return b.Bytes()
+}
@@ -1,9 +1,13 @@
 package term
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
+	"github.com/mattn/go-isatty"
+	"github.com/omakoto/hl2/src/hl/terminfo"
+	"github.com/omakoto/hl2/src/hl/util"
 	"golang.org/x/term"
 )
 
@@ -13,8 +17,74 @@ const (
 
 var (
 	TermWidth = DefaultTermWidth
+
+	// isStdoutTerminal is replaced in tests.
+	isStdoutTerminal = func() bool {
+		return isatty.IsTerminal(os.Stdout.Fd())
+	}
+
+	// terminfoColorDepth is replaced in tests.
+	terminfoColorDepth = colorDepthFromTerminfo
+)
+
+// ColorMode specifies when to use colors.
+type ColorMode int
+
+const (
+	// ColorDefault uses colors unless $NO_COLOR is set, even if the output isn't a terminal.
+	ColorDefault ColorMode = iota
+	// ColorAuto uses colors only if the output is a terminal, unless $NO_COLOR or $FORCE_COLOR is set.
+	ColorAuto
+	// ColorAlways always uses colors.
+	ColorAlways
+	// ColorNever never uses colors.
+	ColorNever
+)
+
+// ParseColorMode converts "always", "never" or "auto" into a ColorMode.
+func ParseColorMode(s string) (ColorMode, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return ColorDefault, nil
+	case "auto":
+		return ColorAuto, nil
+	case "always":
+		return ColorAlways, nil
+	case "never":
+		return ColorNever, nil
+	}
+	return ColorDefault, fmt.Errorf("invalid color mode '%s'; must be always, never or auto", s)
+}
+
+// ColorDepth specifies the number of colors, i.e. which Term implementation to use.
+type ColorDepth int
+
+const (
+	// DepthAuto detects the color depth from the environment.
+	DepthAuto ColorDepth = iota
+	// Depth8 uses ConsoleTerm.
+	Depth8
+	// Depth256 uses Rgb8Term.
+	Depth256
+	// DepthTrueColor uses Rgb24Term.
+	DepthTrueColor
 )
 
+// ParseColorDepth converts "8", "256" or "truecolor" into a ColorDepth.
+func ParseColorDepth(s string) (ColorDepth, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return DepthAuto, nil
+	case "8", "16":
+		return Depth8, nil
+	case "256":
+		return Depth256, nil
+	case "truecolor", "24bit":
+		return DepthTrueColor, nil
+	}
+	return DepthAuto, fmt.Errorf("invalid color depth '%s'; must be 8, 256 or truecolor", s)
+}
+
 func GetTermWidth() int {
 	width, _, err := term.GetSize(1)
 	if err != nil {
@@ -23,19 +93,117 @@ func GetTermWidth() int {
 	return width
 }
 
+// NewDefaultTerm creates a Term based on the environment.
 func NewDefaultTerm() Term {
-	var t Term
-	termEnv := os.Getenv("TERM")
-	if strings.HasPrefix(termEnv, "xterm") {
-		if os.Getenv("COLORTERM") == "truecolor" {
-			t = NewRgb24Term(TermWidth)
-		} else {
-			t = NewRgb8Term(TermWidth)
+	return NewTerm(ColorDefault, DepthAuto)
+}
+
+// NewTerm creates a Term for a given ColorMode and ColorDepth, using the environment
+// variables $NO_COLOR, $FORCE_COLOR, $TERM and $COLORTERM.
+func NewTerm(mode ColorMode, depth ColorDepth) Term {
+	forced := false
+	switch mode {
+	case ColorNever:
+		return NewDumbTerm()
+	case ColorAlways:
+		forced = true
+	default:
+		// See https://force-color.org/ and https://no-color.org/.
+		// FORCE_COLOR takes precedence over NO_COLOR.
+		switch forceColor := os.Getenv("FORCE_COLOR"); forceColor {
+		case "":
+			if os.Getenv("NO_COLOR") != "" {
+				return NewDumbTerm()
+			}
+			if mode == ColorAuto && !isStdoutTerminal() {
+				return NewDumbTerm()
+			}
+		case "0", "false":
+			return NewDumbTerm()
+		default:
+			forced = true
+			if depth == DepthAuto {
+				if forceColor == "2" {
+					depth = Depth256
+				} else if forceColor == "3" {
+					depth = DepthTrueColor
+				}
+			}
 		}
-	} else if termEnv != "" {
-		t = NewConsoleTerm(TermWidth)
-	} else {
-		t = NewDumbTerm()
 	}
-	return t
+	if depth == DepthAuto {
+		depth = detectColorDepth()
+		if depth == DepthAuto && forced {
+			depth = Depth8
+		}
+	}
+
+	switch depth {
+	case Depth8:
+		return NewConsoleTerm(TermWidth)
+	case Depth256:
+		return NewRgb8Term(TermWidth)
+	case DepthTrueColor:
+		return NewRgb24Term(TermWidth)
+	}
+	return NewDumbTerm()
+}
+
+// colorDepthFromTerminfo returns the color depth from the terminfo entry, which is DepthAuto
+// if the entry doesn't support colors. ok is false if there's no entry.
+func colorDepthFromTerminfo(termEnv string) (depth ColorDepth, ok bool) {
+	ti, err := terminfo.Load(termEnv)
+	if err != nil {
+		return DepthAuto, false
+	}
+	switch {
+	case ti.TrueColor():
+		return DepthTrueColor, true
+	case ti.Colors() >= 256:
+		return Depth256, true
+	case ti.Colors() >= 8:
+		return Depth8, true
+	}
+	return DepthAuto, true
+}
+
+// detectColorDepth detects the color depth from $TERM and $COLORTERM, and the terminfo database.
+// Returns DepthAuto if the terminal doesn't support colors.
+func detectColorDepth() ColorDepth {
+	termEnv := os.Getenv("TERM")
+	if termEnv == "" || termEnv == "dumb" {
+		return DepthAuto
+	}
+	switch os.Getenv("COLORTERM") {
+	case "truecolor", "24bit":
+		return DepthTrueColor
+	}
+
+	// Terminfo can only upgrade the guess from $TERM, because a lot of entries under-report
+	// the number of colors; e.g. "xterm" says 8 colors, but virtually all xterm-compatible
+	// terminals support 256 colors. Unknown names are guessed to have 8 colors, so their
+	// entries can upgrade them too.
+	guessed := guessColorDepth(termEnv)
+	if fromTerminfo, ok := terminfoColorDepth(termEnv); ok && fromTerminfo > guessed {
+		util.Debugf("Color depth from terminfo: %d\n", fromTerminfo)
+		return fromTerminfo
+	}
+	return guessed
+}
+
+// guessColorDepth guesses the color depth from the $TERM value.
+func guessColorDepth(termEnv string) ColorDepth {
+	switch {
+	case termEnv == "xterm-kitty" || termEnv == "alacritty" || termEnv == "wezterm",
+		strings.HasPrefix(termEnv, "kitty"),
+		strings.HasSuffix(termEnv, "-direct"):
+		return DepthTrueColor
+	case strings.HasPrefix(termEnv, "xterm"),
+		strings.HasPrefix(termEnv, "tmux"),
+		strings.HasPrefix(termEnv, "screen"),
+		strings.HasPrefix(termEnv, "rxvt-unicode"),
+		strings.HasSuffix(termEnv, "-256color"):
+		return Depth256
+	}
+	return Depth8
 }
@@ -6,9 +6,24 @@ import (
 	"testing"
 )
 
+// noTerminfo makes the tests use the color depths guessed from $TERM, regardless of the
+// terminfo database on the machine.
+func noTerminfo() func() {
+	orig := terminfoColorDepth
+	terminfoColorDepth = func(string) (ColorDepth, bool) { return DepthAuto, false }
+	return func() { terminfoColorDepth = orig }
+}
+
 func TestNewDefaultTerm(t *testing.T) {
+	defer noTerminfo()()
+
 	os.Setenv("TERM", "")
 	os.Setenv("COLORTERM", "")
+	os.Setenv("NO_COLOR", "")
+	os.Setenv("FORCE_COLOR", "")
+	assert.IsType(t, &DumbTerm{}, NewDefaultTerm())
+
+	os.Setenv("TERM", "dumb")
 	assert.IsType(t, &DumbTerm{}, NewDefaultTerm())
 
 	os.Setenv("TERM", "vt100")
@@ -20,7 +35,131 @@ func TestNewDefaultTerm(t *testing.T) {
 	os.Setenv("TERM", "xterm256")
 	assert.IsType(t, &Rgb8Term{}, NewDefaultTerm())
 
+	os.Setenv("TERM", "screen-256color")
+	assert.IsType(t, &Rgb8Term{}, NewDefaultTerm())
+
+	os.Setenv("TERM", "tmux")
+	assert.IsType(t, &Rgb8Term{}, NewDefaultTerm())
+
+	os.Setenv("TERM", "xterm-kitty")
+	assert.IsType(t, &Rgb24Term{}, NewDefaultTerm())
+
+	os.Setenv("TERM", "alacritty")
+	assert.IsType(t, &Rgb24Term{}, NewDefaultTerm())
+
+	os.Setenv("TERM", "wezterm")
+	assert.IsType(t, &Rgb24Term{}, NewDefaultTerm())
+
 	os.Setenv("TERM", "xterm256")
 	os.Setenv("COLORTERM", "truecolor")
 	assert.IsType(t, &Rgb24Term{}, NewDefaultTerm())
+
+	os.Setenv("COLORTERM", "24bit")
+	assert.IsType(t, &Rgb24Term{}, NewDefaultTerm())
+	os.Setenv("COLORTERM", "")
+}
+
+func TestNewTerm(t *testing.T) {
+	defer noTerminfo()()
+	defer func(f func() bool) { isStdoutTerminal = f }(isStdoutTerminal)
+	tty := false
+	isStdoutTerminal = func() bool { return tty }
+
+	os.Setenv("TERM", "xterm")
+	os.Setenv("COLORTERM", "")
+	os.Setenv("NO_COLOR", "")
+	os.Setenv("FORCE_COLOR", "")
+
+	// Default: colors even when not a terminal.
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorDefault, DepthAuto))
+	assert.IsType(t, &DumbTerm{}, NewTerm(ColorAuto, DepthAuto))
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorAlways, DepthAuto))
+	assert.IsType(t, &DumbTerm{}, NewTerm(ColorNever, DepthAuto))
+
+	tty = true
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorAuto, DepthAuto))
+
+	// Explicit depth.
+	assert.IsType(t, &ConsoleTerm{}, NewTerm(ColorDefault, Depth8))
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorDefault, Depth256))
+	assert.IsType(t, &Rgb24Term{}, NewTerm(ColorDefault, DepthTrueColor))
+	assert.IsType(t, &DumbTerm{}, NewTerm(ColorNever, DepthTrueColor))
+
+	// NO_COLOR
+	os.Setenv("NO_COLOR", "1")
+	assert.IsType(t, &DumbTerm{}, NewTerm(ColorDefault, DepthAuto))
+	assert.IsType(t, &DumbTerm{}, NewTerm(ColorAuto, DepthAuto))
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorAlways, DepthAuto))
+
+	// FORCE_COLOR takes precedence over NO_COLOR.
+	tty = false
+	os.Setenv("FORCE_COLOR", "1")
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorAuto, DepthAuto))
+	os.Setenv("FORCE_COLOR", "3")
+	assert.IsType(t, &Rgb24Term{}, NewTerm(ColorAuto, DepthAuto))
+	os.Setenv("FORCE_COLOR", "0")
+	assert.IsType(t, &DumbTerm{}, NewTerm(ColorDefault, DepthAuto))
+	assert.IsType(t, &Rgb8Term{}, NewTerm(ColorAlways, DepthAuto))
+
+	// Forced colors without TERM.
+	os.Setenv("TERM", "")
+	os.Setenv("FORCE_COLOR", "1")
+	assert.IsType(t, &ConsoleTerm{}, NewTerm(ColorAuto, DepthAuto))
+	assert.IsType(t, &ConsoleTerm{}, NewTerm(ColorDefault, DepthAuto))
+
+	os.Setenv("NO_COLOR", "")
+	os.Setenv("FORCE_COLOR", "")
+}
+
+func TestParseColorModeAndDepth(t *testing.T) {
+	m, err := ParseColorMode("always")
+	assert.Nil(t, err)
+	assert.Equal(t, ColorAlways, m)
+	_, err = ParseColorMode("sometimes")
+	assert.NotNil(t, err)
+
+	d, err := ParseColorDepth("truecolor")
+	assert.Nil(t, err)
+	assert.Equal(t, DepthTrueColor, d)
+	d, err = ParseColorDepth("256")
+	assert.Nil(t, err)
+	assert.Equal(t, Depth256, d)
+	_, err = ParseColorDepth("64")
+	assert.NotNil(t, err)
+}
+
+func TestNewTerm_Terminfo(t *testing.T) {
+	defer func(f func(string) (ColorDepth, bool)) { terminfoColorDepth = f }(terminfoColorDepth)
+	depths := map[string]ColorDepth{
+		"vt100":        DepthAuto,
+		"xterm":        Depth8,
+		"fancy":        Depth256,
+		"fancy-direct": DepthTrueColor,
+		"linux-rgb":    DepthTrueColor,
+	}
+	terminfoColorDepth = func(name string) (ColorDepth, bool) {
+		d, ok := depths[name]
+		return d, ok
+	}
+
+	os.Setenv("COLORTERM", "")
+	os.Setenv("NO_COLOR", "")
+	os.Setenv("FORCE_COLOR", "")
+
+	check := func(termEnv string, expected Term) {
+		os.Setenv("TERM", termEnv)
+		assert.IsType(t, expected, NewDefaultTerm(), "TERM=%s", termEnv)
+	}
+
+	// Terminfo can only upgrade the guess from $TERM.
+	check("vt100", &ConsoleTerm{})
+	check("xterm", &Rgb8Term{})
+	check("fancy", &Rgb8Term{})
+	check("fancy-direct", &Rgb24Term{})
+	check("linux-rgb", &Rgb24Term{})
+
+	// Without an entry, the color depth is guessed from $TERM.
+	check("unknown", &ConsoleTerm{})
+	check("xterm-unknown", &Rgb8Term{})
+	check("dumb", &DumbTerm{})
 }
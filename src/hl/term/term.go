@@ -18,10 +18,19 @@ type Term interface {
 
 	CsiReset() []byte
 
+	// LinkStart returns a sequence that starts a hyperlink to a URL, or nil if the terminal
+	// doesn't support hyperlinks.
+	LinkStart(url string) []byte
+
+	// LinkEnd returns a sequence that ends a hyperlink, or nil if the terminal doesn't
+	// support hyperlinks.
+	LinkEnd() []byte
+
 	addColor(b *bytes.Buffer, c colors.Color, base int)
 
 	renderFg(c colors.Color, attrs colors.Attribute) []byte
 	renderBg(c colors.Color) []byte
+	renderUl(c colors.Color) []byte
 }
 
 var _ = Term((*DumbTerm)(nil))
@@ -40,6 +49,14 @@ func (*DumbTerm) CsiReset() []byte {
 	return EmptyBytes
 }
 
+func (*DumbTerm) LinkStart(url string) []byte {
+	return nil
+}
+
+func (*DumbTerm) LinkEnd() []byte {
+	return nil
+}
+
 func (*DumbTerm) renderFg(c colors.Color, attrs colors.Attribute) []byte {
 	return EmptyBytes
 }
@@ -48,41 +65,30 @@ func (*DumbTerm) renderBg(c colors.Color) []byte {
 	return EmptyBytes
 }
 
-func (*DumbTerm) addColor(b *bytes.Buffer, c colors.Color, base int) {
+func (*DumbTerm) renderUl(c colors.Color) []byte {
+	return EmptyBytes
 }
 
-func Color256ToColor8(r, g, b uint8) uint8 {
-	r5 := uint8(int(r) * 5 / 255)
-	g5 := uint8(int(g) * 5 / 255)
-	b5 := uint8(int(b) * 5 / 255)
-	return 16 + 36*r5 + 6*g5 + b5
+func (*DumbTerm) addColor(b *bytes.Buffer, c colors.Color, base int) {
 }
 
-func max8(x, y uint8) uint8 {
-	if x > y {
-		return x
-	}
-	return y
+// Color256ToColor8 returns the xterm 256 palette index that is closest to a RGB color.
+func Color256ToColor8(r, g, b uint8) uint8 {
+	return colors.Nearest256(r, g, b)
 }
 
+// Color256ToIndex returns the ANSI color index [0-15] that is closest to a RGB color.
 func Color256ToIndex(r, g, b uint8) uint8 {
-	max := max8(r, max8(g, b))
-	if max == 0 {
-		return 0
-	}
-	half := max / 2
-	var ret uint8
+	return colors.Nearest16(r, g, b)
+}
 
-	if r >= half {
-		ret++
+// addAnsiColor adds an ANSI color [0-15], using the aixterm codes (90-97, 100-107) for the bright colors.
+func addAnsiColor(b *bytes.Buffer, index uint8, base int) {
+	if index >= 8 {
+		base += 60
+		index -= 8
 	}
-	if g >= half {
-		ret += 2
-	}
-	if b >= half {
-		ret += 4
-	}
-	return ret
+	b.WriteString(strconv.Itoa(base + int(index)))
 }
 
 func addCsiAttributeCode(buffer *bytes.Buffer, attrs colors.Attribute) {
@@ -118,15 +124,23 @@ func (t *ConsoleTerm) CsiReset() []byte {
 	return CsiReset
 }
 
+func (*ConsoleTerm) LinkStart(url string) []byte {
+	return nil
+}
+
+func (*ConsoleTerm) LinkEnd() []byte {
+	return nil
+}
+
 func (t *ConsoleTerm) addColor(b *bytes.Buffer, c colors.Color, base int) {
 	if !c.IsNone() {
-		var index uint8
 		if c.IsIndex() {
-			index = c.Index()
-		} else if c.IsRgb() {
-			index = Color256ToIndex(c.R(), c.G(), c.B())
+			b.WriteString(strconv.Itoa(base + int(c.Index())))
+		} else if c.IsPalette() && c.PaletteIndex() < 16 {
+			addAnsiColor(b, c.PaletteIndex(), base)
+		} else {
+			addAnsiColor(b, Color256ToIndex(c.R(), c.G(), c.B()), base)
 		}
-		b.WriteString(strconv.Itoa(base + int(index)))
 	}
 }
 
@@ -156,6 +170,24 @@ func renderBgInner(t Term, c colors.Color) []byte {
 	return b.Bytes()
 }
 
+// renderUlInner renders an underline color (SGR 58), which only supports
+// the 256 color and the 24 bit color forms.
+func renderUlInner(t Term, c colors.Color) []byte {
+	if c == colors.NoColor {
+		return EmptyBytes
+	}
+	b := bytes.Buffer{}
+	b.Write(CsiStart)
+	if c.IsIndex() {
+		b.WriteString("58;5;")
+		b.WriteString(strconv.Itoa(int(c.Index())))
+	} else {
+		t.addColor(&b, c, 50)
+	}
+	b.Write(CsiEnd)
+	return b.Bytes()
+}
+
 func (t *ConsoleTerm) renderFg(c colors.Color, attrs colors.Attribute) []byte {
 	return renderFgInner(t, c, attrs)
 }
@@ -164,6 +196,11 @@ func (t *ConsoleTerm) renderBg(c colors.Color) []byte {
 	return renderBgInner(t, c)
 }
 
+// renderUl returns an empty code, because the console doesn't support underline colors.
+func (t *ConsoleTerm) renderUl(c colors.Color) []byte {
+	return EmptyBytes
+}
+
 type Rgb8Term struct {
 	width int
 }
@@ -176,16 +213,34 @@ func (*Rgb8Term) CsiReset() []byte {
 	return CsiReset
 }
 
+func (*Rgb8Term) LinkStart(url string) []byte {
+	return renderLinkStart(url)
+}
+
+func (*Rgb8Term) LinkEnd() []byte {
+	return oscLinkEnd
+}
+
 func (t *Rgb8Term) addColor(b *bytes.Buffer, c colors.Color, base int) {
 	if c.IsIndex() {
 		index := c.Index()
 		b.WriteString(strconv.Itoa(base + int(index)))
 		return
 	}
+	if c.IsPalette() {
+		b.WriteString(strconv.Itoa(base + 8))
+		b.WriteString(";5;")
+		b.WriteString(strconv.Itoa(int(c.PaletteIndex())))
+		return
+	}
 	if c.IsRgb() {
+		index, ok := c.CubeIndex()
+		if !ok {
+			index = Color256ToColor8(c.R(), c.G(), c.B())
+		}
 		b.WriteString(strconv.Itoa(base + 8))
 		b.WriteString(";5;")
-		b.WriteString(strconv.Itoa(int(Color256ToColor8(c.R(), c.G(), c.B()))))
+		b.WriteString(strconv.Itoa(int(index)))
 	}
 }
 
@@ -197,6 +252,10 @@ func (t *Rgb8Term) renderBg(c colors.Color) []byte {
 	return renderBgInner(t, c)
 }
 
+func (t *Rgb8Term) renderUl(c colors.Color) []byte {
+	return renderUlInner(t, c)
+}
+
 type Rgb24Term struct {
 	width int
 }
@@ -209,12 +268,26 @@ func (*Rgb24Term) CsiReset() []byte {
 	return CsiReset
 }
 
+func (*Rgb24Term) LinkStart(url string) []byte {
+	return renderLinkStart(url)
+}
+
+func (*Rgb24Term) LinkEnd() []byte {
+	return oscLinkEnd
+}
+
 func (t *Rgb24Term) addColor(b *bytes.Buffer, c colors.Color, base int) {
 	if c.IsIndex() {
 		index := c.Index()
 		b.WriteString(strconv.Itoa(base + int(index)))
 		return
 	}
+	if c.IsPalette() {
+		b.WriteString(strconv.Itoa(base + 8))
+		b.WriteString(";5;")
+		b.WriteString(strconv.Itoa(int(c.PaletteIndex())))
+		return
+	}
 	if c.IsRgb() {
 		b.WriteString(strconv.Itoa(base + 8))
 		b.WriteString(";2;")
@@ -234,6 +307,10 @@ func (t *Rgb24Term) renderBg(c colors.Color) []byte {
 	return renderBgInner(t, c)
 }
 
+func (t *Rgb24Term) renderUl(c colors.Color) []byte {
+	return renderUlInner(t, c)
+}
+
 func NewDumbTerm() *DumbTerm {
 	return &DumbTerm{}
 }
@@ -0,0 +1,111 @@
+package term
+
+import (
+	"testing"
+
+	"github.com/omakoto/hl2/src/hl/colors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderUl(t *testing.T) {
+	red, _ := colors.FromString(":red")
+	rgb, _ := colors.FromString(":ff8000")
+
+	assert.Equal(t, "", string(NewDumbTerm().renderUl(red.Ul())))
+	assert.Equal(t, "", string(NewConsoleTerm(80).renderUl(red.Ul())))
+
+	assert.Equal(t, "\x1b[58;5;1m", string(NewRgb8Term(80).renderUl(red.Ul())))
+	assert.Equal(t, "\x1b[58;5;208m", string(NewRgb8Term(80).renderUl(rgb.Ul())))
+
+	assert.Equal(t, "\x1b[58;5;1m", string(NewRgb24Term(80).renderUl(red.Ul())))
+	assert.Equal(t, "\x1b[58;2;255;128;0m", string(NewRgb24Term(80).renderUl(rgb.Ul())))
+
+	assert.Equal(t, "", string(NewRgb24Term(80).renderUl(colors.NoColor)))
+}
+
+func TestRenderedColors_UlCode(t *testing.T) {
+	term := NewRgb8Term(80)
+	ul, _ := colors.FromString("u:red")
+	fg, _ := colors.FromString("green")
+
+	base := NewRenderedColors(term, ul)
+	top := NewRenderedColors(term, fg)
+	top.SetNext(base)
+
+	assert.Equal(t, "\x1b[32m", string(top.FgCode()))
+	assert.Equal(t, "\x1b[58;5;1m", string(top.UlCode()))
+}
+
+func TestRenderPalette(t *testing.T) {
+	c, _ := colors.FromString("c208/brightred")
+
+	assert.Equal(t, "\x1b[38;5;208m", string(NewRgb8Term(80).renderFg(c.Fg(), c.Attributes())))
+	assert.Equal(t, "\x1b[48;5;9m", string(NewRgb8Term(80).renderBg(c.Bg())))
+
+	assert.Equal(t, "\x1b[38;5;208m", string(NewRgb24Term(80).renderFg(c.Fg(), c.Attributes())))
+	assert.Equal(t, "\x1b[48;5;9m", string(NewRgb24Term(80).renderBg(c.Bg())))
+
+	assert.Equal(t, "\x1b[31m", string(NewConsoleTerm(80).renderFg(c.Fg(), c.Attributes())))
+	assert.Equal(t, "\x1b[101m", string(NewConsoleTerm(80).renderBg(c.Bg())))
+}
+
+func TestRenderDownsampled(t *testing.T) {
+	check := func(spec, rgb8, console string) {
+		c, err := colors.FromString(spec)
+		assert.NoError(t, err)
+		assert.Equal(t, rgb8, string(NewRgb8Term(80).renderFg(c.Fg(), c.Attributes())), spec)
+		assert.Equal(t, console, string(NewConsoleTerm(80).renderFg(c.Fg(), c.Attributes())), spec)
+	}
+
+	// 6x6x6 cube colors keep the exact index.
+	check("500", "\x1b[38;5;196m", "\x1b[91m")
+	check("123", "\x1b[38;5;67m", "\x1b[90m")
+	check("222", "\x1b[38;5;102m", "\x1b[90m")
+
+	// Other RGB colors use the perceptually closest color, including grays.
+	check("444444", "\x1b[38;5;238m", "\x1b[90m")
+	check("eeeeee", "\x1b[38;5;255m", "\x1b[37m")
+	check("ff8000", "\x1b[38;5;208m", "\x1b[31m")
+	check("00ff00", "\x1b[38;5;46m", "\x1b[92m")
+	check("c000c0", "\x1b[38;5;127m", "\x1b[35m")
+}
+
+func TestContrastAdjuster(t *testing.T) {
+	term := NewRgb24Term(80)
+	a := NewContrastAdjuster(term, 4.5)
+
+	line, _ := colors.FromString("/000033")
+	low, _ := colors.FromString("b000099")
+	high, _ := colors.FromString("ffff00")
+	index, _ := colors.FromString("blue")
+
+	lineColors := NewRenderedColors(term, line)
+	r := NewRenderedColors(term, low)
+	r.SetNext(lineColors)
+	assert.Equal(t, "\x1b[1;38;2;99;99;255m", string(a.FgCode(r)))
+	assert.Equal(t, "\x1b[1;38;2;99;99;255m", string(a.FgCode(r))) // Cached.
+
+	r = NewRenderedColors(term, high)
+	r.SetNext(lineColors)
+	assert.Equal(t, "\x1b[38;2;255;255;0m", string(a.FgCode(r)))
+
+	r = NewRenderedColors(term, index)
+	r.SetNext(lineColors)
+	assert.Equal(t, "\x1b[34m", string(a.FgCode(r)))
+
+	// No background.
+	assert.Equal(t, "\x1b[1;38;2;0;0;153m", string(a.FgCode(NewRenderedColors(term, low))))
+}
+
+func TestLinks(t *testing.T) {
+	assert.Nil(t, NewDumbTerm().LinkStart("https://example.com/"))
+	assert.Nil(t, NewDumbTerm().LinkEnd())
+	assert.Nil(t, NewConsoleTerm(80).LinkStart("https://example.com/"))
+	assert.Nil(t, NewConsoleTerm(80).LinkEnd())
+
+	for _, term := range []Term{NewRgb8Term(80), NewRgb24Term(80)} {
+		assert.Equal(t, "\x1b]8;;https://example.com/?a=1;b=2\x1b\\", string(term.LinkStart("https://example.com/?a=1;b=2")))
+		assert.Equal(t, "\x1b]8;;https://example.com/\\x\x1b\\", string(term.LinkStart("https://example.com/\x1b\\\x07\n\x7fx")))
+		assert.Equal(t, "\x1b]8;;\x1b\\", string(term.LinkEnd()))
+	}
+}
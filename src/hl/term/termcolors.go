@@ -6,6 +6,7 @@ type RenderedColors struct {
 	colors *colors.Colors
 	fgCode []byte
 	bgCode []byte
+	ulCode []byte
 
 	next *RenderedColors
 }
@@ -17,10 +18,12 @@ func (r *RenderedColors) String() string {
 func NewRenderedColors(t Term, c *colors.Colors) *RenderedColors {
 	fg := t.renderFg(c.Fg(), c.Attributes())
 	bg := t.renderBg(c.Bg())
+	ul := t.renderUl(c.Ul())
 	return &RenderedColors{
 		colors: c,
 		fgCode: fg,
 		bgCode: bg,
+		ulCode: ul,
 	}
 }
 
@@ -48,6 +51,38 @@ func (r *RenderedColors) BgCode() []byte {
 	return []byte("")
 }
 
+func (r *RenderedColors) UlCode() []byte {
+	if len(r.ulCode) > 0 {
+		return r.ulCode
+	}
+	if r.next != nil {
+		return r.next.UlCode()
+	}
+	return []byte("")
+}
+
+// FgColors returns the foreground color and the attributes that FgCode() comes from.
+func (r *RenderedColors) FgColors() (colors.Color, colors.Attribute) {
+	if len(r.fgCode) > 0 {
+		return r.colors.Fg(), r.colors.Attributes()
+	}
+	if r.next != nil {
+		return r.next.FgColors()
+	}
+	return colors.NoColor, colors.NoAttributes
+}
+
+// BgColor returns the background color that BgCode() comes from.
+func (r *RenderedColors) BgColor() colors.Color {
+	if len(r.bgCode) > 0 {
+		return r.colors.Bg()
+	}
+	if r.next != nil {
+		return r.next.BgColor()
+	}
+	return colors.NoColor
+}
+
 func (r *RenderedColors) SetNext(next *RenderedColors) {
 	r.next = next
 }
@@ -0,0 +1,331 @@
+// Package terminfo reads compiled terminfo entries, as generated by ncurses' tic.
+package terminfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	magicLegacy = 0432  // Numbers are 16 bit.
+	magic32Bit  = 01036 // Numbers are 32 bit.
+
+	// Index of "colors" in the predefined numeric capabilities.
+	colorsIndex = 13
+)
+
+var (
+	ErrNotFound = errors.New("terminfo entry not found")
+)
+
+// Terminfo is a parsed terminfo entry. Only the capabilities that hl is interested in
+// are exposed.
+type Terminfo struct {
+	Names []string
+
+	// Numbers are the predefined numeric capabilities; -1 means absent.
+	Numbers []int
+
+	// ExtBools, ExtNumbers and ExtStrings are the extended (user defined) capabilities.
+	ExtBools   map[string]bool
+	ExtNumbers map[string]int
+	ExtStrings map[string]string
+}
+
+// Colors returns the "colors" capability, or -1 if it's not defined.
+func (t *Terminfo) Colors() int {
+	if len(t.Numbers) <= colorsIndex {
+		return -1
+	}
+	return t.Numbers[colorsIndex]
+}
+
+// TrueColor returns whether the entry has the "Tc" or the "RGB" extended capability,
+// which indicate 24 bit color support.
+func (t *Terminfo) TrueColor() bool {
+	if t.ExtBools["Tc"] || t.ExtBools["RGB"] {
+		return true
+	}
+	if _, ok := t.ExtNumbers["RGB"]; ok {
+		return true
+	}
+	_, ok := t.ExtStrings["RGB"]
+	return ok
+}
+
+// searchDirs returns the directories to look for terminfo entries in, in the same order as ncurses.
+func searchDirs() []string {
+	dirs := make([]string, 0)
+	if d := os.Getenv("TERMINFO"); d != "" {
+		dirs = append(dirs, d)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		dirs = append(dirs, filepath.Join(home, ".terminfo"))
+	}
+	defaults := []string{"/etc/terminfo", "/lib/terminfo", "/usr/share/terminfo", "/usr/lib/terminfo", "/usr/local/share/terminfo"}
+	if d := os.Getenv("TERMINFO_DIRS"); d != "" {
+		for _, dir := range strings.Split(d, ":") {
+			if dir == "" {
+				// An empty entry means the system default.
+				dirs = append(dirs, defaults...)
+			} else {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return append(dirs, defaults...)
+}
+
+// Find returns the path to the compiled entry for a terminal name.
+func Find(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\x00") || name == "." || name == ".." {
+		return "", ErrNotFound
+	}
+	for _, dir := range searchDirs() {
+		// Linux uses the first character as the subdirectory name, and macOS uses its hex code.
+		for _, sub := range []string{name[0:1], fmt.Sprintf("%02x", name[0])} {
+			path := filepath.Join(dir, sub, name)
+			if st, err := os.Stat(path); err == nil && st.Mode().IsRegular() {
+				return path, nil
+			}
+		}
+	}
+	return "", ErrNotFound
+}
+
+// Load finds and parses the entry for a terminal name.
+func Load(name string) (*Terminfo, error) {
+	path, err := Find(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// reader reads little endian values from a compiled entry.
+type reader struct {
+	data []byte
+	pos  int
+}
+
+var errTruncated = errors.New("truncated terminfo entry")
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || r.pos+n > len(r.data) {
+		return nil, errTruncated
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+func (r *reader) int16() (int, error) {
+	b, err := r.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return int(int16(binary.LittleEndian.Uint16(b))), nil
+}
+
+func (r *reader) int32() (int, error) {
+	b, err := r.bytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return int(int32(binary.LittleEndian.Uint32(b))), nil
+}
+
+func (r *reader) int16s(n int) ([]int, error) {
+	ret := make([]int, n)
+	for i := range ret {
+		v, err := r.int16()
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+func (r *reader) numbers(n int, is32Bit bool) ([]int, error) {
+	ret := make([]int, n)
+	for i := range ret {
+		var v int
+		var err error
+		if is32Bit {
+			v, err = r.int32()
+		} else {
+			v, err = r.int16()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v < 0 {
+			v = -1
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+// alignEven skips a padding byte to make the position even.
+func (r *reader) alignEven() {
+	if r.pos%2 != 0 && r.pos < len(r.data) {
+		r.pos++
+	}
+}
+
+// cstring returns the NUL terminated string at offset in table.
+func cstring(table []byte, offset int) (string, bool) {
+	if offset < 0 || offset >= len(table) {
+		return "", false
+	}
+	end := offset
+	for end < len(table) && table[end] != 0 {
+		end++
+	}
+	return string(table[offset:end]), true
+}
+
+// Parse parses a compiled terminfo entry. See term(5).
+func Parse(data []byte) (*Terminfo, error) {
+	r := &reader{data: data}
+	header, err := r.int16s(6)
+	if err != nil {
+		return nil, err
+	}
+	var is32Bit bool
+	switch header[0] {
+	case magicLegacy:
+	case magic32Bit:
+		is32Bit = true
+	default:
+		return nil, fmt.Errorf("invalid terminfo magic number 0%o", header[0])
+	}
+	namesSize, boolCount, numCount, strCount, strTableSize := header[1], header[2], header[3], header[4], header[5]
+	if namesSize < 0 || boolCount < 0 || numCount < 0 || strCount < 0 || strTableSize < 0 {
+		return nil, errors.New("invalid terminfo header")
+	}
+
+	names, err := r.bytes(namesSize)
+	if err != nil {
+		return nil, err
+	}
+	t := &Terminfo{
+		ExtBools:   make(map[string]bool),
+		ExtNumbers: make(map[string]int),
+		ExtStrings: make(map[string]string),
+	}
+	t.Names = strings.Split(strings.TrimRight(string(names), "\x00"), "|")
+
+	if _, err = r.bytes(boolCount); err != nil {
+		return nil, err
+	}
+	r.alignEven()
+	if t.Numbers, err = r.numbers(numCount, is32Bit); err != nil {
+		return nil, err
+	}
+	if _, err = r.bytes(strCount*2 + strTableSize); err != nil {
+		return nil, err
+	}
+
+	// The extended section is optional.
+	r.alignEven()
+	if r.pos >= len(data) {
+		return t, nil
+	}
+	if err = parseExtended(r, t, is32Bit); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func parseExtended(r *reader, t *Terminfo, is32Bit bool) error {
+	header, err := r.int16s(5)
+	if err != nil {
+		return err
+	}
+	boolCount, numCount, strCount, _, tableSize := header[0], header[1], header[2], header[3], header[4]
+	if boolCount < 0 || numCount < 0 || strCount < 0 || tableSize < 0 {
+		return errors.New("invalid extended terminfo header")
+	}
+
+	bools, err := r.bytes(boolCount)
+	if err != nil {
+		return err
+	}
+	r.alignEven()
+	nums, err := r.numbers(numCount, is32Bit)
+	if err != nil {
+		return err
+	}
+	strOffsets, err := r.int16s(strCount)
+	if err != nil {
+		return err
+	}
+	nameOffsets, err := r.int16s(boolCount + numCount + strCount)
+	if err != nil {
+		return err
+	}
+	table, err := r.bytes(tableSize)
+	if err != nil {
+		return err
+	}
+
+	// The string table contains the string values, followed by the capability names.
+	// Name offsets are relative to the end of the last string value.
+	namesStart := 0
+	strValues := make([]string, strCount)
+	for i, off := range strOffsets {
+		s, ok := cstring(table, off)
+		if !ok {
+			continue
+		}
+		strValues[i] = s
+		if end := off + len(s) + 1; end > namesStart {
+			namesStart = end
+		}
+	}
+	name := func(i int) (string, error) {
+		s, ok := cstring(table, namesStart+nameOffsets[i])
+		if !ok {
+			return "", errors.New("invalid extended capability name offset")
+		}
+		return s, nil
+	}
+
+	for i := 0; i < boolCount; i++ {
+		n, err := name(i)
+		if err != nil {
+			return err
+		}
+		t.ExtBools[n] = bools[i] == 1
+	}
+	for i := 0; i < numCount; i++ {
+		n, err := name(boolCount + i)
+		if err != nil {
+			return err
+		}
+		if nums[i] >= 0 {
+			t.ExtNumbers[n] = nums[i]
+		}
+	}
+	for i := 0; i < strCount; i++ {
+		n, err := name(boolCount + numCount + i)
+		if err != nil {
+			return err
+		}
+		if strOffsets[i] >= 0 {
+			t.ExtStrings[n] = strValues[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package terminfo
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Compiled by tic from:
+//
+//	testtc|test, colors#256, Tc, RGB#8, setrgbf=\E[38;2;%p1%d;%p2%d;%p3%dm,
+const testTc = "1a010c0000000e00000000007465737474637c7465737400ffffffffffffffffffffffffffffffffffffffffffffffffffff0001010001000100040029000100080000000000030007001b5b33383b323b25703125643b25703225643b25703325646d00546300524742007365747267626600"
+
+// Compiled by tic from (32 bit numbers):
+//
+//	direct|test direct, colors#0x1000000, RGB, bold=\E[1m,
+const testDirect = "1e02130000000e001c0005006469726563747c74657374206469726563740000ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff00000001ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff00001b5b316d0000010000000000010004000100000052474200"
+
+// Compiled by tic from (no extended capabilities):
+//
+//	plain8|test plain, colors#8, bold=\E[1m,
+const testPlain8 = "1a01120000000e001c000500706c61696e387c7465737420706c61696e00ffffffffffffffffffffffffffffffffffffffffffffffffffff0800ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff00001b5b316d00"
+
+func mustDecode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParse(t *testing.T) {
+	ti, err := Parse(mustDecode(t, testTc))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"testtc", "test"}, ti.Names)
+	assert.Equal(t, 256, ti.Colors())
+	assert.Equal(t, true, ti.TrueColor())
+	assert.Equal(t, map[string]bool{"Tc": true}, ti.ExtBools)
+	assert.Equal(t, map[string]int{"RGB": 8}, ti.ExtNumbers)
+	assert.Equal(t, map[string]string{"setrgbf": "\x1b[38;2;%p1%d;%p2%d;%p3%dm"}, ti.ExtStrings)
+
+	ti, err = Parse(mustDecode(t, testDirect))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"direct", "test direct"}, ti.Names)
+	assert.Equal(t, 0x1000000, ti.Colors())
+	assert.Equal(t, true, ti.TrueColor())
+
+	ti, err = Parse(mustDecode(t, testPlain8))
+	assert.Nil(t, err)
+	assert.Equal(t, 8, ti.Colors())
+	assert.Equal(t, false, ti.TrueColor())
+	assert.Equal(t, 0, len(ti.ExtBools))
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := Parse([]byte{})
+	assert.NotNil(t, err)
+
+	_, err = Parse([]byte("not a terminfo file"))
+	assert.NotNil(t, err)
+
+	data := mustDecode(t, testTc)
+	for _, n := range []int{12, 30, 60, len(data) - 5} {
+		_, err = Parse(data[:n])
+		assert.NotNil(t, err, "length=%d", n)
+	}
+
+	// Negative counts.
+	for i := 2; i < 12; i += 2 {
+		data := []byte{0x1a, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		data[i], data[i+1] = 0xff, 0xff
+		_, err = Parse(data)
+		assert.NotNil(t, err, "offset=%d", i)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "t"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "t", "testtc"), mustDecode(t, testTc), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "70"), 0755))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "70", "plain8"), mustDecode(t, testPlain8), 0644))
+
+	t.Setenv("TERMINFO", dir)
+
+	ti, err := Load("testtc")
+	assert.Nil(t, err)
+	assert.Equal(t, "testtc", ti.Names[0])
+
+	// macOS style hex directory.
+	ti, err = Load("plain8")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain8", ti.Names[0])
+
+	_, err = Load("no-such-terminal")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = Load("../t/testtc")
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// BufferedWriter is a buffered writer, which flushes either after each line, or when the
+// buffer is full. In the latter case, it can also flush buffered data when the input is idle,
+// so the output doesn't stall while waiting for more input. See IdleFlushingReader().
+type BufferedWriter struct {
+	mu sync.Mutex
+
+	w            *bufio.Writer
+	lineBuffered bool
+
+	flushDelay time.Duration
+	timer      *time.Timer
+
+	// err is the error from a delayed flush, which will be returned by the next call.
+	err error
+}
+
+var _ = io.Writer((*BufferedWriter)(nil))
+
+// NewBufferedWriter creates a new BufferedWriter. If lineBuffered is true, it flushes after
+// each write that contains a newline. Otherwise, it flushes when the buffer is full, or
+// when a read from an IdleFlushingReader() blocks for flushDelay, unless flushDelay is 0.
+func NewBufferedWriter(w io.Writer, lineBuffered bool, flushDelay time.Duration) *BufferedWriter {
+	return &BufferedWriter{
+		w:            bufio.NewWriterSize(w, 64*1024),
+		lineBuffered: lineBuffered,
+		flushDelay:   flushDelay,
+	}
+}
+
+func (b *BufferedWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if b.lineBuffered && bytes.IndexByte(p, '\n') >= 0 {
+		return n, b.w.Flush()
+	}
+	return n, nil
+}
+
+// IdleFlushingReader wraps a reader of the input, so the buffered data is flushed when
+// a read from it doesn't return within the flush delay.
+func (b *BufferedWriter) IdleFlushingReader(r io.Reader) io.Reader {
+	if b.lineBuffered || b.flushDelay <= 0 {
+		return r
+	}
+	return &idleFlushingReader{r: r, b: b}
+}
+
+type idleFlushingReader struct {
+	r io.Reader
+	b *BufferedWriter
+}
+
+func (r *idleFlushingReader) Read(p []byte) (int, error) {
+	r.b.startTimer()
+	defer r.b.stopTimer()
+	return r.r.Read(p)
+}
+
+// startTimer starts the delayed flush timer.
+func (b *BufferedWriter) startTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.flushDelay, b.delayedFlush)
+	} else {
+		b.timer.Reset(b.flushDelay)
+	}
+}
+
+// stopTimer stops the delayed flush timer, if it hasn't fired yet.
+func (b *BufferedWriter) stopTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.timer.Stop()
+}
+
+func (b *BufferedWriter) delayedFlush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err == nil && b.w.Buffered() > 0 {
+		b.err = b.w.Flush()
+	}
+}
+
+// Flush writes all the buffered data.
+func (b *BufferedWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return b.err
+	}
+	return b.w.Flush()
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer that can be used from the delayed flush goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func TestBufferedWriter_LineBuffered(t *testing.T) {
+	out := &syncBuffer{}
+	w := NewBufferedWriter(out, true, 0)
+
+	w.Write([]byte("abc"))
+	assert.Equal(t, "", out.String())
+
+	w.Write([]byte("def\nxyz"))
+	assert.Equal(t, "abcdef\nxyz", out.String())
+
+	w.Write([]byte("123"))
+	assert.Equal(t, "abcdef\nxyz", out.String())
+
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "abcdef\nxyz123", out.String())
+}
+
+func TestBufferedWriter_FullyBuffered(t *testing.T) {
+	out := &syncBuffer{}
+	w := NewBufferedWriter(out, false, 0)
+
+	w.Write([]byte("abc\n"))
+	w.Write([]byte("def\n"))
+	assert.Equal(t, "", out.String())
+
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "abc\ndef\n", out.String())
+}
+
+// blockingReader returns the strings sent to it one by one.
+type blockingReader chan string
+
+func (r blockingReader) Read(p []byte) (int, error) {
+	s, ok := <-r
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, s), nil
+}
+
+func TestBufferedWriter_IdleFlush(t *testing.T) {
+	out := &syncBuffer{}
+	w := NewBufferedWriter(out, false, 10*time.Millisecond)
+	in := make(blockingReader)
+	rd := w.IdleFlushingReader(in)
+
+	// Not flushed while the input isn't being read.
+	w.Write([]byte("abc\n"))
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, "", out.String())
+
+	// Flushed once a read blocks.
+	done := make(chan bool)
+	go func() {
+		buf := make([]byte, 10)
+		for {
+			if _, err := rd.Read(buf); err != nil {
+				close(done)
+				return
+			}
+		}
+	}()
+	assert.Eventually(t, func() bool { return out.String() == "abc\n" }, time.Second, time.Millisecond)
+
+	// Not flushed while the input keeps coming.
+	w.Write([]byte("def\n"))
+	for i := 0; i < 10; i++ {
+		in <- "x"
+	}
+	close(in)
+	<-done
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "abc\ndef\n", out.String())
+}
+
+func TestBufferedWriter_IdleFlushingReader_LineBuffered(t *testing.T) {
+	in := make(blockingReader)
+	assert.Equal(t, io.Reader(in), NewBufferedWriter(&syncBuffer{}, true, time.Millisecond).IdleFlushingReader(in))
+	assert.Equal(t, io.Reader(in), NewBufferedWriter(&syncBuffer{}, false, 0).IdleFlushingReader(in))
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("failed")
+}
+
+func TestBufferedWriter_DelayedFlushError(t *testing.T) {
+	w := NewBufferedWriter(failingWriter{}, false, time.Millisecond)
+	in := make(blockingReader)
+	rd := w.IdleFlushingReader(in)
+
+	_, err := w.Write([]byte("abc\n"))
+	assert.NoError(t, err)
+
+	go rd.Read(make([]byte, 10))
+	assert.Eventually(t, func() bool { return w.Flush() != nil }, time.Second, time.Millisecond)
+	_, err = w.Write([]byte("abc\n"))
+	assert.Error(t, err)
+	close(in)
+}
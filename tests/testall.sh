@@ -19,7 +19,7 @@ die() {
   exit 2
 }
 
-unset COLORTERM
+unset COLORTERM NO_COLOR FORCE_COLOR
 
 cd $here || die "$0: can't chdir to $here."
 